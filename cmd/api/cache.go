@@ -0,0 +1,90 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+)
+
+// similarCache holds recent similar movie results, so that clients without
+// a cached copy of their own don't each pay for scoring the same movie. It is
+// bounded, evicting the least recently used entry once full.
+type similarCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type similarCacheEntry struct {
+	key      string
+	movies   []data.Movie
+	metadata data.Metadata
+}
+
+// newSimilarCache returns a cache holding up to size results. A size of zero
+// disables caching.
+func newSimilarCache(size int) *similarCache {
+	return &similarCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the result stored under key. The movies are copies, so callers
+// are free to fill them in for their own response.
+func (c *similarCache) Get(key string) ([]*data.Movie, data.Metadata, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, found := c.entries[key]
+	if !found {
+		return nil, data.Metadata{}, false
+	}
+
+	c.order.MoveToFront(element)
+
+	entry := element.Value.(*similarCacheEntry)
+
+	movies := make([]*data.Movie, len(entry.movies))
+	for i := range entry.movies {
+		movie := entry.movies[i]
+		movies[i] = &movie
+	}
+
+	return movies, entry.metadata, true
+}
+
+// Set stores a result under key. It must be called before the movies are
+// modified for a response.
+func (c *similarCache) Set(key string, movies []*data.Movie, metadata data.Metadata) {
+
+	if c.size <= 0 {
+		return
+	}
+
+	entry := &similarCacheEntry{key: key, movies: make([]data.Movie, len(movies)), metadata: metadata}
+	for i, movie := range movies {
+		entry.movies[i] = *movie
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, found := c.entries[key]; found {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*similarCacheEntry).key)
+	}
+}
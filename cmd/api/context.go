@@ -9,7 +9,10 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey  = contextKey("user")
+	tokenContextKey = contextKey("token")
+)
 
 func (app *application) contextSetUser(request *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(request.Context(), userContextKey, user)
@@ -24,3 +27,16 @@ func (app *application) contextGetUser(request *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetToken records the bearer token a request was authenticated with.
+func (app *application) contextSetToken(request *http.Request, tokenPlaintext string) *http.Request {
+	ctx := context.WithValue(request.Context(), tokenContextKey, tokenPlaintext)
+	return request.WithContext(ctx)
+}
+
+// contextGetToken returns the bearer token the request was authenticated
+// with, or "" for anonymous requests.
+func (app *application) contextGetToken(request *http.Request) string {
+	token, _ := request.Context().Value(tokenContextKey).(string)
+	return token
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+)
+
+// movieETag derives a strong entity tag from a movie's ID and version. Every
+// update bumps the version, so the tag changes whenever the movie does. The
+// rating aggregates change without a version bump, so they are folded in
+// separately.
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d-%d-%.2f"`, movie.ID, movie.Version, movie.RatingCount, movie.AverageRating)
+}
+
+// localizedETag folds the languages titles were negotiated for into etag,
+// since the same movies are represented differently in each. Tags for
+// requests without a language preference are left as they are.
+func localizedETag(etag string, languages []string) string {
+	if len(languages) == 0 {
+		return etag
+	}
+
+	return strings.TrimSuffix(etag, `"`) + ";" + strings.Join(languages, "+") + `"`
+}
+
+// movieListETag derives an entity tag for a page of movies from the tags of
+// the individual movies plus the pagination metadata and any facet counts,
+// which together determine the response body.
+func movieListETag(movies []*data.Movie, metadata data.Metadata, facets *data.Facets) string {
+	h := sha256.New()
+
+	for _, movie := range movies {
+		fmt.Fprintf(h, "%s,", movieETag(movie))
+	}
+
+	js, _ := json.Marshal(metadata)
+	h.Write(js)
+
+	if facets != nil {
+		js, _ = json.Marshal(facets)
+		h.Write(js)
+	}
+
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// similarETag derives an entity tag for a page of similar movies without
+// scoring them. The results follow from the source movie, the state of
+// everything they are scored on, and the page requested.
+func similarETag(source *data.Movie, state string, fields []string, filters data.Filters) string {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%s,%s,%s,%d,%d", movieETag(source), state, strings.Join(fields, ","), filters.Page, filters.PageSize)
+
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether etag is listed in an If-Match or If-None-Match
+// header value. A "*" matches any current representation. When weak is true
+// the W/ prefix is ignored, as If-None-Match requires; If-Match uses the
+// strong comparison.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return true
+		}
+
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified answers a conditional GET with 304 Not Modified when the
+// client's If-None-Match header matches etag, and reports whether it did.
+func (app *application) notModified(response http.ResponseWriter, request *http.Request, etag string) bool {
+	header := request.Header.Get("If-None-Match")
+	if header == "" || !etagMatches(header, etag, true) {
+		return false
+	}
+
+	response.Header().Set("ETag", etag)
+	response.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// preconditionFailed checks the client's If-Match header against etag. If it
+// doesn't match, a 412 response is sent and true is returned. Requests
+// without an If-Match header always pass.
+func (app *application) preconditionFailed(response http.ResponseWriter, request *http.Request, etag string) bool {
+	header := request.Header.Get("If-Match")
+	if header == "" {
+		return false
+	}
+
+	// A tag from a localized read still names the same version of the
+	// resource, so any language folded in by localizedETag is ignored.
+	candidates := strings.Split(header, ",")
+	for i, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if tag, _, found := strings.Cut(candidate, ";"); found {
+			candidate = tag + `"`
+		}
+		candidates[i] = candidate
+	}
+
+	if etagMatches(strings.Join(candidates, ","), etag, false) {
+		return false
+	}
+
+	app.preconditionFailedResponse(response, request)
+	return true
+}
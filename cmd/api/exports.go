@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+)
+
+// exportWriteTimeout is how long the client gets to take each batch of an
+// export. The deadline is pushed back after every batch, so an export can
+// run for as long as it needs to, unlike other responses, while a client
+// that stops reading is still cut off.
+const exportWriteTimeout = 30 * time.Second
+
+// exportMoviesHandler streams every movie matching the same filters and sort
+// order as listMoviesHandler, either as CSV or as NDJSON. Rows are
+// written as they are read from the database instead of being collected and
+// marshalled in one go.
+func (app *application) exportMoviesHandler(response http.ResponseWriter, request *http.Request) {
+
+	var input struct {
+		data.MovieQuery
+		Format string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := request.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.SearchMode = app.readString(qs, "search_mode", "plain")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.PersonID = int64(app.readInt(qs, "person_id", 0, v))
+	input.GenresMode = app.readString(qs, "genres_mode", "all")
+	input.ExcludeGenres = app.readCSV(qs, "exclude_genres", []string{})
+	input.YearMin = int32(app.readInt(qs, "year_min", 0, v))
+	input.YearMax = int32(app.readInt(qs, "year_max", 0, v))
+	input.RuntimeMin = int32(app.readInt(qs, "runtime_min", 0, v))
+	input.RuntimeMax = int32(app.readInt(qs, "runtime_max", 0, v))
+	input.Format = app.readString(qs, "format", "csv")
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = movieSortSafelist
+	input.Filters.SortColumns = movieSortColumns
+
+	data.ValidateMovieQuery(v, input.MovieQuery, input.Filters)
+	v.Check(validator.In(input.Format, "csv", "ndjson"), "format", "must be csv or ndjson")
+	v.Check(validator.In(input.Filters.Sort, input.Filters.SortSafelist...), "sort", "invalid sort value")
+
+	err := app.resolveGenreFilters(&input.MovieQuery)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	if data.ValidateMovieGenreFilters(v, input.MovieQuery); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	var (
+		write   func(*data.Movie) error
+		flush   func() error
+		started bool
+	)
+
+	csvWriter := csv.NewWriter(response)
+	jsonEncoder := json.NewEncoder(response)
+
+	// The status line and headers are only sent once the first row is ready,
+	// so that a failing query can still be reported as a normal JSON error.
+	start := func() {
+		started = true
+
+		if input.Format == "ndjson" {
+			response.Header().Set("Content-Type", "application/x-ndjson")
+		} else {
+			response.Header().Set("Content-Type", "text/csv")
+		}
+		response.Header().Set("Content-Disposition", `attachment; filename="movies.`+input.Format+`"`)
+		response.WriteHeader(http.StatusOK)
+
+		if input.Format == "csv" {
+			csvWriter.Write([]string{"id", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count"})
+		}
+	}
+
+	switch input.Format {
+	case "ndjson":
+		write = func(movie *data.Movie) error {
+			return jsonEncoder.Encode(movie)
+		}
+		flush = func() error { return nil }
+	default:
+		write = func(movie *data.Movie) error {
+			return csvWriter.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.Itoa(int(movie.Year)),
+				strconv.Itoa(int(movie.Runtime)),
+				strings.Join(movie.Genres, "|"),
+				strconv.Itoa(int(movie.Version)),
+				strconv.FormatFloat(movie.AverageRating, 'f', 2, 64),
+				strconv.Itoa(int(movie.RatingCount)),
+			})
+		}
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	}
+
+	// The server's WriteTimeout would otherwise cut a large export short
+	// after the headers have gone out, leaving the client with a file that
+	// looks complete.
+	controller := http.NewResponseController(response)
+
+	err = controller.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	count := 0
+
+	err = app.models.Movies.Export(request.Context(), input.MovieQuery, input.Filters, func(movie *data.Movie) error {
+		if !started {
+			start()
+		}
+
+		err := write(movie)
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%500 == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := controller.Flush(); err != nil {
+				return err
+			}
+			if err := controller.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+
+		// Part of the body has already been sent, so all that can be done is
+		// to log the failure and cut the response short.
+		app.logError(request, err)
+		return
+	}
+
+	if !started {
+		start()
+	}
+
+	err = flush()
+	if err != nil {
+		app.logError(request, err)
+	}
+}
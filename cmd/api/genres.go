@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+)
+
+// validateMovie resolves the aliases among movie's genres to their slugs and
+// then validates the movie against the current genre vocabulary.
+func (app *application) validateMovie(v *validator.Validator, movie *data.Movie) error {
+
+	vocab, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		return err
+	}
+
+	movie.Genres = vocab.Resolve(movie.Genres)
+
+	data.ValidateMovie(v, movie, vocab)
+
+	return nil
+}
+
+// resolveGenreFilters resolves aliases in the genre filters of q, so that
+// listing by a genre's old name or a merged-away genre still finds its movies.
+// Repeats are kept, for data.ValidateMovieGenreFilters to report.
+func (app *application) resolveGenreFilters(q *data.MovieQuery) error {
+
+	if len(q.Genres) == 0 && len(q.ExcludeGenres) == 0 {
+		return nil
+	}
+
+	vocab, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		return err
+	}
+
+	for i := range q.Genres {
+		q.Genres[i] = vocab.Lookup(q.Genres[i])
+	}
+	for i := range q.ExcludeGenres {
+		q.ExcludeGenres[i] = vocab.Lookup(q.ExcludeGenres[i])
+	}
+
+	return nil
+}
+
+func slugifyAll(names []string) []string {
+
+	if names == nil {
+		return nil
+	}
+
+	slugs := make([]string, len(names))
+	for i, name := range names {
+		slugs[i] = data.Slugify(name)
+	}
+
+	return slugs
+}
+
+func (app *application) listGenresHandler(response http.ResponseWriter, request *http.Request) {
+
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) showGenreHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// createGenreHandler adds a genre to the vocabulary. The slug defaults to the
+// slugified name, and aliases are slugified the same way movie genres are.
+func (app *application) createGenreHandler(response http.ResponseWriter, request *http.Request) {
+
+	var input struct {
+		Name    string   `json:"name"`
+		Slug    string   `json:"slug"`
+		Aliases []string `json:"aliases"`
+	}
+
+	err := app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	if input.Slug == "" {
+		input.Slug = data.Slugify(input.Name)
+	}
+
+	genre := &data.Genre{
+		Name:    input.Name,
+		Slug:    input.Slug,
+		Aliases: slugifyAll(input.Aliases),
+	}
+
+	if genre.Aliases == nil {
+		genre.Aliases = []string{}
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Insert(genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("slug", "a genre with this slug or alias already exists")
+			app.failedValidationResponse(response, request, v.Errors)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%d", genre.ID))
+
+	err = app.writeJSON(response, http.StatusCreated, envelope{"genre": genre}, headers)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// updateGenreHandler renames a genre or replaces its aliases. Changing the
+// slug retags every movie using it.
+func (app *application) updateGenreHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name    *string  `json:"name"`
+		Slug    *string  `json:"slug"`
+		Aliases []string `json:"aliases"`
+	}
+
+	err = app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	if input.Name != nil {
+		genre.Name = *input.Name
+	}
+
+	if input.Slug != nil {
+		genre.Slug = *input.Slug
+	}
+
+	if input.Aliases != nil {
+		genre.Aliases = slugifyAll(input.Aliases)
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Update(genre, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(response, request)
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("slug", "a genre with this slug or alias already exists")
+			app.failedValidationResponse(response, request, v.Errors)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// mergeGenreHandler folds the genre in the URL into the one given by
+// target_id, and responds with the merged genre.
+func (app *application) mergeGenreHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	var input struct {
+		TargetID int64 `json:"target_id"`
+	}
+
+	err = app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.TargetID > 0, "target_id", "must be provided")
+	v.Check(input.TargetID != id, "target_id", "must be a different genre")
+
+	if !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	genre, err := app.models.Genres.Merge(id, input.TargetID, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
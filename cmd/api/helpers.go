@@ -17,11 +17,15 @@ import (
 type envelope map[string]interface{}
 
 func (app *application) readIDParam(request *http.Request) (int64, error) {
+	return app.readNamedIDParam(request, "id")
+}
+
+func (app *application) readNamedIDParam(request *http.Request, name string) (int64, error) {
 
 	params := httprouter.ParamsFromContext(request.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 	return id, nil
 
@@ -142,6 +146,29 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 }
 
+// readIDs reads a comma-separated list of positive IDs, dropping repeats so
+// that each ID appears once, in the position it first appeared.
+func (app *application) readIDs(qs url.Values, key string, v *validator.Validator) []int64 {
+
+	ids := []int64{}
+	seen := map[int64]bool{}
+
+	for _, s := range app.readCSV(qs, key, []string{}) {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil || id < 1 {
+			v.AddError(key, "must be a comma-separated list of positive integers")
+			return nil
+		}
+
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
 func (app *application) background(fn func()) {
 	// increment the WaitGroup counter
 	app.wg.Add(1)
@@ -159,3 +186,39 @@ func (app *application) background(fn func()) {
 
 	}()
 }
+
+// wantsField reports whether field should be included in a response limited
+// to fields with ?fields=. Every field is wanted when none were listed.
+func wantsField(fields []string, field string) bool {
+	return len(fields) == 0 || validator.In(field, fields...)
+}
+
+// pickFields returns v reduced to the top-level JSON keys in fields, or v
+// unchanged when fields is empty. Keys v would omit stay omitted.
+func pickFields(v interface{}, fields []string) interface{} {
+
+	if len(fields) == 0 {
+		return v
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	var all map[string]json.RawMessage
+
+	err = json.Unmarshal(js, &all)
+	if err != nil {
+		return v
+	}
+
+	picked := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			picked[field] = value
+		}
+	}
+
+	return picked
+}
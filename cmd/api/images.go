@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/imaging"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	imageExtensions = map[string]string{
+		"image/jpeg": ".jpg",
+		"image/png":  ".png",
+		"image/gif":  ".gif",
+	}
+
+	// thumbnailSizes gives the box each kind of image is scaled to fit.
+	thumbnailSizes = map[string][2]int{
+		data.ImagePoster:   {300, 450},
+		data.ImageBackdrop: {640, 360},
+	}
+)
+
+// uploadMovieImageHandler stores the file in the "image" field of a
+// multipart/form-data body as the movie's poster or backdrop, along with a
+// JPEG thumbnail, replacing any previous image of that kind.
+func (app *application) uploadMovieImageHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	kind := httprouter.ParamsFromContext(request.Context()).ByName("kind")
+	if !validator.In(kind, data.ImageKinds...) {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	maxBytes := app.config.images.maxBytes
+
+	// Leave some room for the multipart framing around the file itself.
+	request.Body = http.MaxBytesReader(response, request.Body, maxBytes+1<<20)
+
+	reader, err := request.MultipartReader()
+	if err != nil {
+		app.unsupportedMediaTypeResponse(response, request)
+		return
+	}
+
+	v := validator.New()
+	tooLarge := fmt.Sprintf("must not be larger than %d bytes", maxBytes)
+
+	var contents []byte
+
+	for {
+		var part *multipart.Part
+
+		part, err = reader.NextPart()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			break
+		}
+
+		if part.FormName() == "image" {
+			contents, err = io.ReadAll(io.LimitReader(part, maxBytes+1))
+			break
+		}
+	}
+
+	// A body well over the limit trips the outer MaxBytesReader before the
+	// image's own limit is reached, which is still the image being too large.
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			v.AddError("image", tooLarge)
+			app.failedValidationResponse(response, request, v.Errors)
+		default:
+			app.badRequestResponse(response, request, err)
+		}
+		return
+	}
+
+	contentType := http.DetectContentType(contents)
+
+	v.Check(len(contents) > 0, "image", "must be provided")
+	v.Check(int64(len(contents)) <= maxBytes, "image", tooLarge)
+	v.Check(validator.In(contentType, imaging.ContentTypes...), "image", "must be a JPEG, PNG or GIF image")
+
+	if !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	img, err := imaging.Decode(contents)
+	if err != nil {
+		switch {
+		case errors.Is(err, imaging.ErrTooLarge):
+			v.AddError("image", fmt.Sprintf("must not have more than %d pixels", imaging.MaxPixels))
+		default:
+			v.AddError("image", "could not be decoded")
+		}
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	size := thumbnailSizes[kind]
+
+	var thumbnail bytes.Buffer
+
+	err = imaging.EncodeJPEG(&thumbnail, imaging.Thumbnail(img, size[0], size[1]))
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	// A random suffix gives every upload its own URL, so caches never serve
+	// a replaced image.
+	suffix := make([]byte, 8)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	name := fmt.Sprintf("movies/%d/%s-%s", id, kind, hex.EncodeToString(suffix))
+
+	image := &data.Image{
+		MovieID:      id,
+		Kind:         kind,
+		Key:          name + imageExtensions[contentType],
+		ThumbnailKey: name + "-thumb.jpg",
+		ContentType:  contentType,
+		Width:        int32(img.Bounds().Dx()),
+		Height:       int32(img.Bounds().Dy()),
+		Size:         int64(len(contents)),
+	}
+
+	ctx := request.Context()
+
+	err = app.storage.Put(ctx, image.Key, bytes.NewReader(contents), contentType)
+	if err == nil {
+		err = app.storage.Put(ctx, image.ThumbnailKey, &thumbnail, "image/jpeg")
+	}
+
+	if err == nil {
+		err = app.models.Images.Set(image, app.contextGetUser(request).ID)
+	}
+
+	if err != nil {
+		// Nothing refers to the new files, so don't leave them behind.
+		app.storage.Delete(ctx, image.Key)
+		app.storage.Delete(ctx, image.ThumbnailKey)
+
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"image": image}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) removeMovieImageHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	kind := httprouter.ParamsFromContext(request.Context()).ByName("kind")
+	if !validator.In(kind, data.ImageKinds...) {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	err = app.models.Images.Remove(id, kind, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// loadImages fills in the Images of each movie with one query for all of
+// them, rather than one per movie.
+func (app *application) loadImages(movies ...*data.Movie) error {
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	images, err := app.models.Images.GetForMovies(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		movie.Images = images[movie.ID]
+	}
+
+	return nil
+}
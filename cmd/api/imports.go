@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+)
+
+const (
+	importModeAtomic     = "atomic"
+	importModeBestEffort = "best_effort"
+)
+
+// importMinBytesPerSecond is the slowest upload an import has to allow for.
+// The server's read timeout is far too short for a body near the size limit,
+// so imports get a deadline scaled to the limit instead.
+const importMinBytesPerSecond = 256 << 10
+
+// importRow is a single movie read from an import body. Errors holds either
+// the reason the row could not be parsed or its validation errors.
+type importRow struct {
+	Row    int               `json:"row"`
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+	movie  *data.Movie
+}
+
+type importReport struct {
+	Mode      string      `json:"mode"`
+	TotalRows int         `json:"total_rows"`
+	Created   []importRow `json:"created"`
+	Failed    []importRow `json:"failed"`
+}
+
+// importMoviesHandler creates movies in bulk from an NDJSON or CSV body. In
+// atomic mode nothing is inserted unless every row is valid; in best_effort
+// mode the valid rows are inserted and the rest are reported as failed. It is
+// served at POST /v1/imports/movies, since POST /v1/movies/import would clash
+// with the /v1/movies/:id routes in httprouter.
+func (app *application) importMoviesHandler(response http.ResponseWriter, request *http.Request) {
+
+	v := validator.New()
+
+	mode := app.readString(request.URL.Query(), "mode", importModeAtomic)
+	if v.Check(validator.In(mode, importModeAtomic, importModeBestEffort), "mode", "must be atomic or best_effort"); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil {
+		app.unsupportedMediaTypeResponse(response, request)
+		return
+	}
+
+	// The write deadline covers the whole request, upload included, so it is
+	// pushed back along with the read deadline.
+	timeout := 10*time.Second + time.Duration(app.config.imports.maxBytes/importMinBytesPerSecond)*time.Second
+
+	controller := http.NewResponseController(response)
+
+	err = controller.SetReadDeadline(time.Now().Add(timeout))
+	if err == nil {
+		err = controller.SetWriteDeadline(time.Now().Add(timeout + 30*time.Second))
+	}
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	request.Body = http.MaxBytesReader(response, request.Body, app.config.imports.maxBytes)
+
+	var rows []importRow
+
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson":
+		rows, err = app.readImportNDJSON(request.Body)
+	case "text/csv":
+		rows, err = app.readImportCSV(request.Body)
+	default:
+		app.unsupportedMediaTypeResponse(response, request)
+		return
+	}
+
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(response, request, fmt.Errorf("body must not be larger than %d bytes", app.config.imports.maxBytes))
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			app.badRequestResponse(response, request, fmt.Errorf("body must be uploaded within %s", timeout))
+		default:
+			app.badRequestResponse(response, request, err)
+		}
+		return
+	}
+
+	if len(rows) == 0 {
+		app.badRequestResponse(response, request, errors.New("body must contain at least one movie"))
+		return
+	}
+
+	report := importReport{
+		Mode:      mode,
+		TotalRows: len(rows),
+		Created:   []importRow{},
+		Failed:    []importRow{},
+	}
+
+	vocab, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	var valid []importRow
+
+	for _, row := range rows {
+		// Rows that could not be decoded at all have no movie to validate;
+		// the rest are validated on top of any per-field parse errors.
+		if row.movie != nil {
+			v := validator.New()
+			for key, message := range row.Errors {
+				v.AddError(key, message)
+			}
+			row.movie.Genres = vocab.Resolve(row.movie.Genres)
+			if data.ValidateMovie(v, row.movie, vocab); !v.Valid() {
+				row.Errors = v.Errors
+			}
+		}
+
+		if row.Errors != nil {
+			report.Failed = append(report.Failed, row)
+			continue
+		}
+
+		valid = append(valid, row)
+	}
+
+	if mode == importModeAtomic && len(report.Failed) > 0 {
+		err = app.writeJSON(response, http.StatusUnprocessableEntity, envelope{"import": report}, nil)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	movies := make([]*data.Movie, len(valid))
+	for i := range valid {
+		movies[i] = valid[i].movie
+	}
+
+	err = app.models.Movies.InsertMany(movies, app.config.imports.batchSize)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	for _, row := range valid {
+		row.ID = row.movie.ID
+		report.Created = append(report.Created, row)
+	}
+
+	status := http.StatusCreated
+	if len(report.Created) == 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	err = app.writeJSON(response, status, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// readImportNDJSON reads one movie object per line. Lines that are not valid
+// movie objects are recorded against their row rather than failing the whole
+// import; only errors reading the body itself are returned.
+func (app *application) readImportNDJSON(body io.Reader) ([]importRow, error) {
+
+	dec := json.NewDecoder(body)
+
+	var rows []importRow
+
+	for n := 1; ; n++ {
+		var raw json.RawMessage
+
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+
+		var syntaxError *json.SyntaxError
+		if errors.As(err, &syntaxError) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("body contains badly-formed JSON (row %d)", n)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var input struct {
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+		}
+
+		row := importRow{Row: n}
+
+		rowDec := json.NewDecoder(bytes.NewReader(raw))
+		rowDec.DisallowUnknownFields()
+
+		err = rowDec.Decode(&input)
+		if err != nil {
+			row.Errors = map[string]string{"row": importDecodeError(err)}
+			rows = append(rows, row)
+			continue
+		}
+
+		row.movie = &data.Movie{
+			Title:   input.Title,
+			Year:    input.Year,
+			Runtime: input.Runtime,
+			Genres:  input.Genres,
+		}
+
+		rows = append(rows, row)
+	}
+}
+
+func importDecodeError(err error) string {
+	var unmarshalTypeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &unmarshalTypeError):
+		return fmt.Sprintf("incorrect JSON type for field %q", unmarshalTypeError.Field)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return "unknown key " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	case errors.Is(err, data.ErrInvalidRuntimeFormat):
+		return err.Error()
+	default:
+		return "must be a JSON object describing a movie"
+	}
+}
+
+// readImportCSV reads movies from CSV with a header row naming the title,
+// year, runtime and genres columns. Multiple genres within a cell are
+// separated by "|", and runtime may be given in any form data.ParseRuntime
+// accepts.
+func (app *application) readImportCSV(body io.Reader) ([]importRow, error) {
+
+	r := csv.NewReader(body)
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.In(name, "title", "year", "runtime", "genres") {
+			return nil, fmt.Errorf("header contains unknown column %q", name)
+		}
+		columns[name] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("header must contain a %q column", name)
+		}
+	}
+
+	var rows []importRow
+
+	for n := 1; ; n++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return nil, fmt.Errorf("body contains badly-formed CSV (row %d)", n)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := importRow{Row: n, Errors: map[string]string{}, movie: &data.Movie{}}
+
+		row.movie.Title = strings.TrimSpace(record[columns["title"]])
+
+		if s := strings.TrimSpace(record[columns["year"]]); s != "" {
+			year, err := strconv.ParseInt(s, 10, 32)
+			if err != nil {
+				row.Errors["year"] = "must be an integer value"
+			}
+			row.movie.Year = int32(year)
+		}
+
+		if s := strings.TrimSpace(record[columns["runtime"]]); s != "" {
+			runtime, err := data.ParseRuntime(s)
+			if err != nil {
+				row.Errors["runtime"] = err.Error()
+			}
+			row.movie.Runtime = runtime
+		}
+
+		if s := strings.TrimSpace(record[columns["genres"]]); s != "" {
+			row.movie.Genres = []string{}
+			for _, genre := range strings.Split(s, "|") {
+				row.movie.Genres = append(row.movie.Genres, strings.TrimSpace(genre))
+			}
+		}
+
+		if len(row.Errors) == 0 {
+			row.Errors = nil
+		}
+
+		rows = append(rows, row)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// keyedLimiter rate limits actions by an arbitrary key, such as an email
+// address, in the same way rateLimit limits requests by client IP.
+type keyedLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*keyedLimiterEntry
+}
+
+type keyedLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newKeyedLimiter returns a limiter allowing burst actions per key at once,
+// refilled at one per every. Keys unused for longer than it takes to refill
+// completely are forgotten.
+func newKeyedLimiter(every time.Duration, burst int) *keyedLimiter {
+
+	l := &keyedLimiter{
+		limit:    rate.Every(every),
+		burst:    burst,
+		limiters: make(map[string]*keyedLimiterEntry),
+	}
+
+	idle := every * time.Duration(burst)
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			l.mu.Lock()
+
+			for key, entry := range l.limiters {
+				if time.Since(entry.lastSeen) > idle {
+					delete(l.limiters, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+// Allow reports whether another action may happen for key now.
+func (l *keyedLimiter) Allow(key string) bool {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, found := l.limiters[key]
+	if !found {
+		entry = &keyedLimiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = entry
+	}
+
+	entry.lastSeen = time.Now()
+
+	return entry.limiter.Allow()
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// readOwnList loads the list named by the id URL parameter, provided it
+// belongs to the current user. Lists belonging to anyone else are reported
+// as not found, so that their existence isn't revealed. When false is
+// returned a response has already been sent.
+func (app *application) readOwnList(response http.ResponseWriter, request *http.Request) (*data.List, bool) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return nil, false
+	}
+
+	list, err := app.models.Lists.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return nil, false
+	}
+
+	if list.UserID != app.contextGetUser(request).ID {
+		app.notFoundResponse(response, request)
+		return nil, false
+	}
+
+	return list, true
+}
+
+// writeListWithEntries writes a list, along with its entries, as the
+// response.
+func (app *application) writeListWithEntries(response http.ResponseWriter, request *http.Request, list *data.List, status int) {
+
+	entries, err := app.models.Lists.GetEntries(list.ID)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	list.Entries = entries
+
+	err = app.writeJSON(response, status, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) listOwnListsHandler(response http.ResponseWriter, request *http.Request) {
+
+	user := app.contextGetUser(request)
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := request.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "created_at")
+
+	input.Filters.SortSafelist = []string{"created_at", "name", "-created_at", "-name"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	visibilities := []string{data.VisibilityPrivate, data.VisibilityUnlisted, data.VisibilityPublic}
+
+	lists, metadata, err := app.models.Lists.GetAll(user.ID, visibilities, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"lists": lists, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) createListHandler(response http.ResponseWriter, request *http.Request) {
+
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility"`
+	}
+
+	err := app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	list := &data.List{
+		UserID:      app.contextGetUser(request).ID,
+		Name:        input.Name,
+		Description: input.Description,
+		Visibility:  input.Visibility,
+	}
+
+	if list.Visibility == "" {
+		list.Visibility = data.VisibilityPrivate
+	}
+
+	v := validator.New()
+
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.Insert(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateListName):
+			v.AddError("name", "you already have a list with this name")
+			app.failedValidationResponse(response, request, v.Errors)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/lists/%d", list.ID))
+
+	err = app.writeJSON(response, http.StatusCreated, envelope{"list": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) showOwnListHandler(response http.ResponseWriter, request *http.Request) {
+
+	list, ok := app.readOwnList(response, request)
+	if !ok {
+		return
+	}
+
+	app.writeListWithEntries(response, request, list, http.StatusOK)
+}
+
+func (app *application) updateListHandler(response http.ResponseWriter, request *http.Request) {
+
+	list, ok := app.readOwnList(response, request)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Visibility  *string `json:"visibility"`
+	}
+
+	err := app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	v := validator.New()
+
+	if input.Name != nil {
+		v.Check(!list.IsDefault || *input.Name == list.Name, "name", "the default list cannot be renamed")
+		list.Name = *input.Name
+	}
+
+	if input.Description != nil {
+		list.Description = *input.Description
+	}
+
+	if input.Visibility != nil {
+		list.Visibility = *input.Visibility
+	}
+
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.Update(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateListName):
+			v.AddError("name", "you already have a list with this name")
+			app.failedValidationResponse(response, request, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) deleteListHandler(response http.ResponseWriter, request *http.Request) {
+
+	list, ok := app.readOwnList(response, request)
+	if !ok {
+		return
+	}
+
+	if list.IsDefault {
+		v := validator.New()
+		v.AddError("list", "the default list cannot be deleted")
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err := app.models.Lists.Delete(list.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"message": "list successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) addListEntryHandler(response http.ResponseWriter, request *http.Request) {
+
+	list, ok := app.readOwnList(response, request)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MovieID  int64  `json:"movie_id"`
+		Position int32  `json:"position"`
+		Note     string `json:"note"`
+	}
+
+	err := app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	entry := &data.ListEntry{
+		ListID:   list.ID,
+		MovieID:  input.MovieID,
+		Position: input.Position,
+		Note:     input.Note,
+	}
+
+	v := validator.New()
+
+	if data.ValidateListEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.AddEntry(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "no movie exists with this id")
+			app.failedValidationResponse(response, request, v.Errors)
+		case errors.Is(err, data.ErrDuplicateListEntry):
+			v.AddError("movie_id", "this movie is already on the list")
+			app.failedValidationResponse(response, request, v.Errors)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	app.writeListWithEntries(response, request, list, http.StatusCreated)
+}
+
+func (app *application) updateListEntryHandler(response http.ResponseWriter, request *http.Request) {
+
+	list, ok := app.readOwnList(response, request)
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readNamedIDParam(request, "movie_id")
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	var input struct {
+		Position *int32  `json:"position"`
+		Note     *string `json:"note"`
+	}
+
+	err = app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	entries, err := app.models.Lists.GetEntries(list.ID)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	var entry *data.ListEntry
+	for _, e := range entries {
+		if e.MovieID == movieID {
+			entry = e
+			break
+		}
+	}
+
+	if entry == nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	if input.Position != nil {
+		entry.Position = *input.Position
+	}
+
+	if input.Note != nil {
+		entry.Note = *input.Note
+	}
+
+	v := validator.New()
+
+	if data.ValidateListEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.UpdateEntry(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	app.writeListWithEntries(response, request, list, http.StatusOK)
+}
+
+func (app *application) removeListEntryHandler(response http.ResponseWriter, request *http.Request) {
+
+	list, ok := app.readOwnList(response, request)
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readNamedIDParam(request, "movie_id")
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	err = app.models.Lists.RemoveEntry(list.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	app.writeListWithEntries(response, request, list, http.StatusOK)
+}
+
+// listPublicListsHandler lists public lists, optionally only those belonging
+// to a given user. Unlisted lists are never included here; they can only be
+// fetched by their share token.
+func (app *application) listPublicListsHandler(response http.ResponseWriter, request *http.Request) {
+
+	var input struct {
+		UserID int64
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := request.URL.Query()
+
+	input.UserID = int64(app.readInt(qs, "user_id", 0, v))
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+
+	input.Filters.SortSafelist = []string{"created_at", "name", "-created_at", "-name"}
+
+	v.Check(input.UserID >= 0, "user_id", "must be a positive integer")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	lists, metadata, err := app.models.Lists.GetAll(input.UserID, []string{data.VisibilityPublic}, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	user := app.contextGetUser(request)
+	for _, list := range lists {
+		hideShareToken(list, user)
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"lists": lists, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// showListHandler shows any list the current user is allowed to see. The
+// URL holds either a list's ID, which works for the user's own lists and
+// public lists, or its share token, which also works for unlisted lists.
+func (app *application) showListHandler(response http.ResponseWriter, request *http.Request) {
+
+	user := app.contextGetUser(request)
+
+	var (
+		list    *data.List
+		visible func(*data.User) bool
+		err     error
+	)
+
+	if id, idErr := app.readIDParam(request); idErr == nil {
+		list, err = app.models.Lists.Get(id)
+		if list != nil {
+			visible = list.Visible
+		}
+	} else {
+		token := httprouter.ParamsFromContext(request.Context()).ByName("id")
+		list, err = app.models.Lists.GetByShareToken(token)
+		if list != nil {
+			visible = list.VisibleByShareToken
+		}
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	if !visible(user) {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	hideShareToken(list, user)
+
+	app.writeListWithEntries(response, request, list, http.StatusOK)
+}
+
+// hideShareToken clears the share token of a list that doesn't belong to
+// user. Only the owner decides who else gets it, and a public list may
+// later be made unlisted.
+func hideShareToken(list *data.List, user *data.User) {
+	if list.UserID != user.ID {
+		list.ShareToken = ""
+	}
+}
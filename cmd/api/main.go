@@ -15,6 +15,7 @@ import (
 	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
 	"github.com/Emmanuel-MacAnThony/greenlight/internal/jsonlog"
 	"github.com/Emmanuel-MacAnThony/greenlight/internal/mailer"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/storage"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -45,14 +46,46 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	imports struct {
+		maxBytes  int64
+		batchSize int
+	}
+	trash struct {
+		retention time.Duration
+	}
+	batch struct {
+		maxIDs int
+	}
+	similar struct {
+		maxAge    time.Duration
+		cacheSize int
+	}
+	auth struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	activation struct {
+		resendInterval time.Duration
+		resendBurst    int
+	}
+	images struct {
+		dir      string
+		baseURL  string
+		maxBytes int64
+	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	// activationLimiter limits activation emails by address, so the resend
+	// endpoint can't be used to flood someone's inbox.
+	activationLimiter *keyedLimiter
+	similarCache      *similarCache
+	wg                sync.WaitGroup
 }
 
 func main() {
@@ -92,6 +125,25 @@ func main() {
 		return nil
 	})
 
+	flag.Int64Var(&cfg.imports.maxBytes, "import-max-bytes", 50<<20, "Maximum body size for movie imports in bytes")
+	flag.IntVar(&cfg.imports.batchSize, "import-batch-size", 500, "Number of rows per INSERT statement for movie imports")
+
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept before being purged (0 disables purging)")
+
+	flag.IntVar(&cfg.batch.maxIDs, "batch-max-ids", 100, "Maximum number of IDs in a batch movie fetch")
+	flag.DurationVar(&cfg.similar.maxAge, "similar-max-age", 5*time.Minute, "How long clients may cache similar movie results")
+	flag.IntVar(&cfg.similar.cacheSize, "similar-cache-size", 1000, "Number of similar movie results cached by the server (0 disables the cache)")
+
+	flag.DurationVar(&cfg.auth.accessTTL, "auth-access-ttl", 15*time.Minute, "How long authentication tokens are valid for")
+	flag.DurationVar(&cfg.auth.refreshTTL, "auth-refresh-ttl", 30*24*time.Hour, "How long refresh tokens are valid for")
+
+	flag.DurationVar(&cfg.activation.resendInterval, "activation-resend-interval", 10*time.Minute, "How often an activation email may be resent to the same address")
+	flag.IntVar(&cfg.activation.resendBurst, "activation-resend-burst", 2, "Activation emails that may be resent to the same address at once")
+
+	flag.StringVar(&cfg.images.dir, "images-dir", "./uploads", "Directory movie images are stored in")
+	flag.StringVar(&cfg.images.baseURL, "images-base-url", "/v1/images", "Base URL movie images are served from")
+	flag.Int64Var(&cfg.images.maxBytes, "images-max-bytes", 10<<20, "Maximum size of an uploaded movie image in bytes")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -103,6 +155,12 @@ func main() {
 
 	}
 
+	// Each imported row binds four parameters, and PostgreSQL allows at most
+	// 65535 in one statement.
+	if cfg.imports.batchSize < 1 || cfg.imports.batchSize > 65535/4 {
+		logger.PrintFatal(fmt.Errorf("import-batch-size must be between 1 and %d", 65535/4), nil)
+	}
+
 	db, err := openDB(cfg)
 
 	if err != nil {
@@ -130,13 +188,24 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	store, err := storage.NewLocal(cfg.images.dir, cfg.images.baseURL)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
-		logger: logger,
-		config: cfg,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		logger:  logger,
+		config:  cfg,
+		models:  data.NewModels(db, store),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+
+		activationLimiter: newKeyedLimiter(cfg.activation.resendInterval, cfg.activation.resendBurst),
+		similarCache:      newSimilarCache(cfg.similar.cacheSize),
 	}
 
+	go app.purgeExpiredTrash()
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -138,6 +138,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		request = app.contextSetUser(request, user)
+		request = app.contextSetToken(request, token)
 
 		next.ServeHTTP(response, request)
 	})
@@ -221,7 +222,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					if request.Method == http.MethodOptions && request.Header.Get("Access-Control-Request-Method") != "" {
 
 						response.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						response.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						response.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match")
 
 						response.WriteHeader(http.StatusOK)
 						return
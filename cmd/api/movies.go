@@ -3,12 +3,20 @@ package main
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
 
 	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/jsonpatch"
 	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
 )
 
+var (
+	movieSortSafelist = []string{"id", "title", "year", "runtime", "rating", "-id", "-title", "-year", "-runtime", "-rating", "relevance"}
+	movieSortColumns  = map[string]string{"rating": "average_rating"}
+)
+
 func (app *application) createMovieHandler(response http.ResponseWriter, request *http.Request) {
 	var input struct {
 		Title   string       `json:"title"`
@@ -32,7 +40,15 @@ func (app *application) createMovieHandler(response http.ResponseWriter, request
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	movie.RuntimeFormat = app.readRuntimeFormat(request.URL.Query(), v)
+
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(response, request, v.Errors)
 		return
 	}
@@ -61,7 +77,19 @@ func (app *application) showMovieHandler(response http.ResponseWriter, request *
 		app.badRequestResponse(response, request, err)
 		return
 	}
-	movie, err := app.models.Movies.Get(id)
+
+	v := validator.New()
+
+	fields := app.readCSV(request.URL.Query(), "fields", []string{})
+	runtimeFormat := app.readRuntimeFormat(request.URL.Query(), v)
+	languages := app.readLanguages(request, v)
+
+	if data.ValidateMovieFields(v, fields); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.GetFields(id, fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -73,7 +101,45 @@ func (app *application) showMovieHandler(response http.ResponseWriter, request *
 
 	}
 
-	err = app.writeJSON(response, http.StatusOK, envelope{"movie": movie}, nil)
+	// The title depends on Accept-Language. Add rather than set Vary, which
+	// the middleware has already used.
+	response.Header().Add("Vary", "Accept-Language")
+
+	etag := localizedETag(movieETag(movie), languages)
+	if app.notModified(response, request, etag) {
+		return
+	}
+
+	if wantsField(fields, "credits") {
+		err = app.loadCredits(movie)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	if wantsField(fields, "images") {
+		err = app.loadImages(movie)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	if wantsField(fields, "title") {
+		err = app.localizeTitles(languages, movie)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	movie.RuntimeFormat = runtimeFormat
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"movie": pickFields(movie, fields)}, headers)
 
 	if err != nil {
 		app.serverErrorResponse(response, request, err)
@@ -101,43 +167,86 @@ func (app *application) updateMovieHandler(response http.ResponseWriter, request
 		return
 	}
 
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-
-	err = app.readJSON(response, request, &input)
-	if err != nil {
-		app.badRequestResponse(response, request, err)
+	if app.preconditionFailed(response, request, movieETag(movie)) {
 		return
 	}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
+	// The content type selects the patch semantics: plain JSON only updates
+	// the fields present in the body, while the merge-patch and json-patch
+	// types follow RFC 7396 and RFC 6902 respectively.
+	mediaType := "application/json"
+	if contentType := request.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			app.unsupportedMediaTypeResponse(response, request)
+			return
+		}
 	}
 
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
+	switch mediaType {
+	case "application/json":
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
 
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
+		err = app.readJSON(response, request, &input)
+		if err != nil {
+			app.badRequestResponse(response, request, err)
+			return
+		}
+
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
 
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+
+		if input.Genres != nil {
+			movie.Genres = input.Genres
+		}
+
+	case "application/merge-patch+json", "application/json-patch+json":
+		err = app.patchMovie(response, request, movie, mediaType)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrPathNotFound), errors.Is(err, jsonpatch.ErrTestFailed):
+				app.patchConflictResponse(response, request, err)
+			default:
+				app.badRequestResponse(response, request, err)
+			}
+			return
+		}
+
+	default:
+		app.unsupportedMediaTypeResponse(response, request)
+		return
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	movie.RuntimeFormat = app.readRuntimeFormat(request.URL.Query(), v)
+
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(response, request, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(movie, app.contextGetUser(request).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -148,7 +257,16 @@ func (app *application) updateMovieHandler(response http.ResponseWriter, request
 		return
 	}
 
-	err = app.writeJSON(response, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.loadCredits(movie)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"movie": movie}, headers)
 
 	if err != nil {
 		app.serverErrorResponse(response, request, err)
@@ -164,12 +282,38 @@ func (app *application) deleteMovieHandler(response http.ResponseWriter, request
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	// With If-Match, the delete only goes ahead if the movie is still at the
+	// version the tag was checked against, so a concurrent update can't slip
+	// in between the check and the delete.
+	var version int32
+
+	if request.Header.Get("If-Match") != "" {
+		movie, err := app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(response, request)
+			default:
+				app.serverErrorResponse(response, request, err)
+			}
+			return
+		}
+
+		if app.preconditionFailed(response, request, movieETag(movie)) {
+			return
+		}
+
+		version = movie.Version
+	}
+
+	err = app.models.Movies.Delete(id, version)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(response, request)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(response, request)
 		default:
 			app.serverErrorResponse(response, request, err)
 		}
@@ -185,9 +329,17 @@ func (app *application) deleteMovieHandler(response http.ResponseWriter, request
 
 func (app *application) listMoviesHandler(response http.ResponseWriter, request *http.Request) {
 
+	// ?ids= asks for specific movies rather than a filtered page of them.
+	if request.URL.Query().Has("ids") {
+		app.batchGetMoviesHandler(response, request)
+		return
+	}
+
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieQuery
+		Facets        []string
+		RuntimeFormat data.RuntimeFormat
+		Languages     []string
 		data.Filters
 	}
 
@@ -196,27 +348,223 @@ func (app *application) listMoviesHandler(response http.ResponseWriter, request
 	qs := request.URL.Query()
 
 	input.Title = app.readString(qs, "title", "")
+	input.SearchMode = app.readString(qs, "search_mode", "plain")
+	input.Facets = app.readCSV(qs, "facets", []string{})
+	input.Fields = app.readCSV(qs, "fields", []string{})
+	input.RuntimeFormat = app.readRuntimeFormat(qs, v)
+	input.Languages = app.readLanguages(request, v)
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.PersonID = int64(app.readInt(qs, "person_id", 0, v))
+	input.GenresMode = app.readString(qs, "genres_mode", "all")
+	input.ExcludeGenres = app.readCSV(qs, "exclude_genres", []string{})
+	input.YearMin = int32(app.readInt(qs, "year_min", 0, v))
+	input.YearMax = int32(app.readInt(qs, "year_max", 0, v))
+	input.RuntimeMin = int32(app.readInt(qs, "runtime_min", 0, v))
+	input.RuntimeMax = int32(app.readInt(qs, "runtime_max", 0, v))
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.CursorMode = qs.Has("cursor")
+
+	input.Filters.SortSafelist = movieSortSafelist
+	input.Filters.SortColumns = movieSortColumns
+
+	data.ValidateMovieQuery(v, input.MovieQuery, input.Filters)
+	data.ValidateMovieFacets(v, input.Facets)
+	data.ValidateMovieFields(v, input.Fields)
+
+	data.ValidateFilters(v, input.Filters)
+
+	err := app.resolveGenreFilters(&input.MovieQuery)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	if data.ValidateMovieGenreFilters(v, input.MovieQuery); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	var (
+		movies   []*data.Movie
+		metadata data.Metadata
+		facets   *data.Facets
+	)
+
+	if len(input.Facets) > 0 {
+		movies, metadata, facets, err = app.models.Movies.GetAllWithFacets(input.MovieQuery, input.Filters, input.Facets)
+	} else {
+		movies, metadata, err = app.models.Movies.GetAll(input.MovieQuery, input.Filters)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidCursor):
+			v.AddError("cursor", "must be a valid cursor")
+			app.failedValidationResponse(response, request, v.Errors)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	// The titles depend on Accept-Language. Add rather than set Vary, which
+	// the middleware has already used.
+	response.Header().Add("Vary", "Accept-Language")
+
+	etag := localizedETag(movieListETag(movies, metadata, facets), input.Languages)
+	if app.notModified(response, request, etag) {
+		return
+	}
+
+	if wantsField(input.Fields, "credits") {
+		err = app.loadCredits(movies...)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	if wantsField(input.Fields, "images") {
+		err = app.loadImages(movies...)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	if wantsField(input.Fields, "title") {
+		err = app.localizeTitles(input.Languages, movies...)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	picked := make([]interface{}, len(movies))
+	for i, movie := range movies {
+		movie.RuntimeFormat = input.RuntimeFormat
+		picked[i] = pickFields(movie, input.Fields)
+	}
+
+	env := envelope{"movies": picked, "metadata": metadata}
+	if facets != nil {
+		env["facets"] = facets
+	}
+
+	err = app.writeJSON(response, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+
+}
+
+// batchGetMoviesHandler returns the movies listed in ?ids= in the order they
+// were asked for, with one query rather than one request per movie. IDs that
+// don't belong to a movie are listed under "missing" instead.
+func (app *application) batchGetMoviesHandler(response http.ResponseWriter, request *http.Request) {
 
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	var input struct {
+		IDs           []int64
+		Fields        []string
+		RuntimeFormat data.RuntimeFormat
+		Languages     []string
+	}
+
+	v := validator.New()
+
+	qs := request.URL.Query()
 
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	input.IDs = app.readIDs(qs, "ids", v)
+	input.Fields = app.readCSV(qs, "fields", []string{})
+	input.RuntimeFormat = app.readRuntimeFormat(qs, v)
+	input.Languages = app.readLanguages(request, v)
+
+	v.Check(len(input.IDs) > 0, "ids", "must contain at least one id")
+	v.Check(len(input.IDs) <= app.config.batch.maxIDs, "ids", fmt.Sprintf("must not contain more than %d ids", app.config.batch.maxIDs))
+
+	if data.ValidateMovieFields(v, input.Fields); !v.Valid() {
 		app.failedValidationResponse(response, request, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, err := app.models.Movies.GetMany(input.IDs, input.Fields)
 	if err != nil {
 		app.serverErrorResponse(response, request, err)
 		return
 	}
 
-	err = app.writeJSON(response, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	found := make(map[int64]bool, len(movies))
+	for _, movie := range movies {
+		found[movie.ID] = true
+	}
+
+	missing := []int64{}
+	for _, id := range input.IDs {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	response.Header().Add("Vary", "Accept-Language")
+
+	// The missing IDs follow from the request and the movies found, so the
+	// movies alone determine the response.
+	etag := localizedETag(movieListETag(movies, data.Metadata{}, nil), input.Languages)
+	if app.notModified(response, request, etag) {
+		return
+	}
+
+	if wantsField(input.Fields, "credits") {
+		err = app.loadCredits(movies...)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	if wantsField(input.Fields, "images") {
+		err = app.loadImages(movies...)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	if wantsField(input.Fields, "title") {
+		err = app.localizeTitles(input.Languages, movies...)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	picked := make([]interface{}, len(movies))
+	for i, movie := range movies {
+		movie.RuntimeFormat = input.RuntimeFormat
+		picked[i] = pickFields(movie, input.Fields)
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"movies": picked, "missing": missing}, headers)
 	if err != nil {
 		app.serverErrorResponse(response, request, err)
 	}
+}
+
+// readRuntimeFormat reads the runtime_format query string parameter, which
+// picks how runtimes are written in movie responses.
+func (app *application) readRuntimeFormat(qs url.Values, v *validator.Validator) data.RuntimeFormat {
+
+	format := app.readString(qs, "runtime_format", string(data.RuntimeFormatMins))
+
+	v.Check(validator.In(format, data.RuntimeFormats...), "runtime_format", "must be one of mins, minutes, hours or iso8601")
 
+	return data.RuntimeFormat(format)
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/jsonpatch"
+)
+
+// moviePatchDocument is the JSON document that merge patches and JSON
+// patches operate on. Only the client-editable fields are included, so a
+// patch can never touch the ID or version.
+type moviePatchDocument struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+}
+
+// patchMovie applies an RFC 7396 merge patch or an RFC 6902 JSON patch from
+// the request body to movie. Fields removed by the patch are left at their
+// zero value so that ValidateMovie reports them as missing.
+func (app *application) patchMovie(response http.ResponseWriter, request *http.Request, movie *data.Movie, mediaType string) error {
+
+	var patch json.RawMessage
+
+	err := app.readJSON(response, request, &patch)
+	if err != nil {
+		return err
+	}
+
+	doc, err := json.Marshal(moviePatchDocument{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case "application/merge-patch+json":
+		doc, err = jsonpatch.MergePatch(doc, patch)
+	default:
+		doc, err = jsonpatch.Apply(doc, patch)
+	}
+	if err != nil {
+		return err
+	}
+
+	var patched moviePatchDocument
+
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&patched)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		switch {
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("patched movie contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("patched movie contains unknown key %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.Is(err, data.ErrInvalidRuntimeFormat):
+			return err
+		default:
+			return errors.New("patched movie must be a JSON object")
+		}
+	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = patched.Runtime
+	movie.Genres = patched.Genres
+
+	return nil
+}
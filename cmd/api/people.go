@@ -0,0 +1,365 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+)
+
+func (app *application) createPersonHandler(response http.ResponseWriter, request *http.Request) {
+	var input struct {
+		Name      string `json:"name"`
+		BirthYear int32  `json:"birth_year"`
+		Biography string `json:"biography"`
+	}
+
+	err := app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	person := &data.Person{
+		Name:      input.Name,
+		BirthYear: input.BirthYear,
+		Biography: input.Biography,
+	}
+
+	v := validator.New()
+
+	if data.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.People.Insert(person)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/people/%d", person.ID))
+
+	err = app.writeJSON(response, http.StatusCreated, envelope{"person": person}, headers)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) showPersonHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	person, err := app.models.People.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"person": person}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) updatePersonHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	person, err := app.models.People.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name      *string `json:"name"`
+		BirthYear *int32  `json:"birth_year"`
+		Biography *string `json:"biography"`
+	}
+
+	err = app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	if input.Name != nil {
+		person.Name = *input.Name
+	}
+
+	if input.BirthYear != nil {
+		person.BirthYear = *input.BirthYear
+	}
+
+	if input.Biography != nil {
+		person.Biography = *input.Biography
+	}
+
+	v := validator.New()
+
+	if data.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.People.Update(person, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"person": person}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) deletePersonHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	err = app.models.People.Delete(id, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"message": "person successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) listPeopleHandler(response http.ResponseWriter, request *http.Request) {
+
+	var input struct {
+		Name string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := request.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+
+	input.Filters.SortSafelist = []string{"id", "name", "birth_year", "-id", "-name", "-birth_year"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	people, metadata, err := app.models.People.GetAll(input.Name, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"people": people, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// addMovieCreditHandler credits a person on a movie in a given role. Posting
+// the same person and role again updates the character and billing order.
+func (app *application) addMovieCreditHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	var input struct {
+		PersonID     int64  `json:"person_id"`
+		Role         string `json:"role"`
+		Character    string `json:"character"`
+		BillingOrder int32  `json:"billing_order"`
+	}
+
+	err = app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	credit := &data.Credit{
+		MovieID:      movie.ID,
+		PersonID:     input.PersonID,
+		Role:         input.Role,
+		Character:    input.Character,
+		BillingOrder: input.BillingOrder,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCredit(v, credit); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.People.AddCredit(credit, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("person_id", "no person exists with this id")
+			app.failedValidationResponse(response, request, v.Errors)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	app.writeMovieWithCredits(response, request, movie.ID, http.StatusOK)
+}
+
+func (app *application) removeMovieCreditHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	personID, err := app.readNamedIDParam(request, "person_id")
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	v := validator.New()
+
+	role := app.readString(request.URL.Query(), "role", "")
+	if v.Check(role == "" || validator.In(role, data.CreditRoles...), "role", "must be one of director, writer or actor"); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.models.People.RemoveCredit(id, personID, role, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	app.writeMovieWithCredits(response, request, id, http.StatusOK)
+}
+
+// writeMovieWithCredits re-reads a movie after its credits have changed and
+// writes it, with its new version and ETag, as the response.
+func (app *application) writeMovieWithCredits(response http.ResponseWriter, request *http.Request, id int64, status int) {
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.loadCredits(movie)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(response, status, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// loadCredits fills in the Credits of each movie with one query for all of
+// them, rather than one per movie.
+func (app *application) loadCredits(movies ...*data.Movie) error {
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	credits, err := app.models.People.GetCreditsForMovies(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		movie.Credits = credits[movie.ID]
+	}
+
+	return nil
+}
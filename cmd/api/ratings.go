@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+)
+
+func (app *application) listMovieRatingsHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := request.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+
+	input.Filters.SortSafelist = []string{"created_at", "score", "-created_at", "-score"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	ratings, metadata, err := app.models.Ratings.GetAllForMovie(id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"ratings": ratings, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) createRatingHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	var input struct {
+		Score  int32  `json:"score"`
+		Review string `json:"review"`
+	}
+
+	err = app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	rating := &data.Rating{
+		MovieID: id,
+		UserID:  app.contextGetUser(request).ID,
+		Score:   input.Score,
+		Review:  input.Review,
+	}
+
+	v := validator.New()
+
+	if data.ValidateRating(v, rating); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.Ratings.Insert(rating)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		case errors.Is(err, data.ErrDuplicateRating):
+			v.AddError("rating", "you have already rated this movie")
+			app.failedValidationResponse(response, request, v.Errors)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusCreated, envelope{"rating": rating}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) showRatingHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	rating, err := app.models.Ratings.GetForUser(id, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"rating": rating}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) updateRatingHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	rating, err := app.models.Ratings.GetForUser(id, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	var input struct {
+		Score  *int32  `json:"score"`
+		Review *string `json:"review"`
+	}
+
+	err = app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	if input.Score != nil {
+		rating.Score = *input.Score
+	}
+
+	if input.Review != nil {
+		rating.Review = *input.Review
+	}
+
+	v := validator.New()
+
+	if data.ValidateRating(v, rating); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.Ratings.Update(rating)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"rating": rating}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) deleteRatingHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	err = app.models.Ratings.Delete(id, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"message": "rating successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+)
+
+func (app *application) listMovieRevisionsHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := request.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-version")
+
+	input.Filters.SortSafelist = []string{"version", "-version"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	// Look the movie up first so that an unknown or trashed movie is a 404
+	// rather than an empty list of revisions.
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	revisions, metadata, err := app.models.Revisions.GetAllForMovie(id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// revertMovieHandler restores the field values recorded in a revision. The
+// revert is an ordinary versioned update, so it is itself recorded as a new
+// revision and fails with an edit conflict if the movie changes concurrently.
+func (app *application) revertMovieHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	revisionID, err := app.readNamedIDParam(request, "revision_id")
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	if app.preconditionFailed(response, request, movieETag(movie)) {
+		return
+	}
+
+	revision, err := app.models.Revisions.Get(id, revisionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	movie.Title = revision.Movie.Title
+	movie.Year = revision.Movie.Year
+	movie.Runtime = revision.Movie.Runtime
+	movie.Genres = revision.Movie.Genres
+
+	v := validator.New()
+
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Update(movie, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.loadCredits(movie)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"expvar"
 	"net/http"
 
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/storage"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -18,15 +19,81 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
+	// Imports live under /v1/imports rather than at /v1/movies/import because
+	// httprouter won't register a static segment beside the :id wildcard.
+	router.HandlerFunc(http.MethodPost, "/v1/imports/movies", app.requirePermission("movies:write", app.importMoviesHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.addMovieCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:person_id", app.requirePermission("movies:write", app.removeMovieCreditHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/titles", app.requirePermission("movies:read", app.listMovieTitlesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/titles/:language", app.requirePermission("movies:write", app.setMovieTitleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/titles/:language", app.requirePermission("movies:write", app.removeMovieTitleHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/images/:kind", app.requirePermission("movies:write", app.uploadMovieImageHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/images/:kind", app.requirePermission("movies:write", app.removeMovieImageHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.requirePermission("movies:read", app.listSimilarMoviesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/ratings", app.requirePermission("movies:read", app.listMovieRatingsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/rating", app.requireActivatedUser(app.showRatingHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/rating", app.requireActivatedUser(app.createRatingHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/rating", app.requireActivatedUser(app.updateRatingHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/rating", app.requireActivatedUser(app.deleteRatingHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:revision_id/revert", app.requirePermission("movies:write", app.revertMovieHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("movies:admin", app.createGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:id", app.requirePermission("movies:read", app.showGenreHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requirePermission("movies:admin", app.updateGenreHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres/:id/merge", app.requirePermission("movies:admin", app.mergeGenreHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/trash/movies", app.requirePermission("movies:admin", app.listTrashedMoviesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/trash/movies/:id/restore", app.requirePermission("movies:admin", app.restoreMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/trash/movies/:id", app.requirePermission("movies:admin", app.purgeMovieHandler))
+
+	// Backends that serve their own files, like the local filesystem one,
+	// are mounted under the path of their base URL. Others hand out URLs
+	// pointing elsewhere.
+	if files, ok := app.storage.(storage.Server); ok && files.MountPath() != "" {
+		router.Handler(http.MethodGet, files.MountPath()+"/*filepath", files)
+	}
+
+	router.HandlerFunc(http.MethodGet, "/v1/exports/movies", app.requirePermission("movies:read", app.exportMoviesHandler))
 
 	// Add the route for the POST /v1/users endpoint.
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireAuthenticatedUser(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.revokeOtherSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireAuthenticatedUser(app.revokeSessionHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/lists", app.requireActivatedUser(app.listOwnListsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/lists", app.requireActivatedUser(app.createListHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/lists/:id", app.requireActivatedUser(app.showOwnListHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/lists/:id", app.requireActivatedUser(app.updateListHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/lists/:id", app.requireActivatedUser(app.deleteListHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/lists/:id/entries", app.requireActivatedUser(app.addListEntryHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/lists/:id/entries/:movie_id", app.requireActivatedUser(app.updateListEntryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/lists/:id/entries/:movie_id", app.requireActivatedUser(app.removeListEntryHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/lists", app.requirePermission("movies:read", app.listPublicListsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/lists/:id", app.requirePermission("movies:read", app.showListHandler))
 
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
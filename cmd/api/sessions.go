@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+)
+
+// listSessionsHandler lists the user's active logins, so they can spot ones
+// they don't recognise and revoke them.
+func (app *application) listSessionsHandler(response http.ResponseWriter, request *http.Request) {
+
+	user := app.contextGetUser(request)
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(user.ID, app.contextGetToken(request))
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) revokeSessionHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	user := app.contextGetUser(request)
+
+	err = app.models.Tokens.RevokeSession(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// revokeOtherSessionsHandler logs the user out everywhere except from the
+// session making the request.
+func (app *application) revokeOtherSessionsHandler(response http.ResponseWriter, request *http.Request) {
+
+	user := app.contextGetUser(request)
+
+	revoked, err := app.models.Tokens.RevokeOtherSessions(user.ID, app.contextGetToken(request))
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"revoked": revoked}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+)
+
+// listSimilarMoviesHandler lists the movies most like the one in the URL,
+// best match first. Scoring every candidate is much heavier than a plain
+// listing, so the ETag is derived without scoring, clients may cache the
+// response for -similar-max-age, and recent results are cached server-side.
+func (app *application) listSimilarMoviesHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	var input struct {
+		Fields        []string
+		RuntimeFormat data.RuntimeFormat
+		Languages     []string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := request.URL.Query()
+
+	input.Fields = app.readCSV(qs, "fields", []string{})
+	input.RuntimeFormat = app.readRuntimeFormat(qs, v)
+	input.Languages = app.readLanguages(request, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Results are always ranked by similarity.
+	input.Filters.Sort = "-similarity"
+	input.Filters.SortSafelist = []string{"-similarity"}
+
+	data.ValidateMovieFields(v, input.Fields)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	source, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	state, err := app.models.Movies.SimilarityState()
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	response.Header().Add("Vary", "Accept-Language")
+
+	// Set directly so that 304 responses carry it too.
+	response.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(app.config.similar.maxAge.Seconds())))
+
+	key := similarETag(source, state, input.Fields, input.Filters)
+
+	etag := localizedETag(key, input.Languages)
+	if app.notModified(response, request, etag) {
+		return
+	}
+
+	movies, metadata, found := app.similarCache.Get(key)
+	if !found {
+		movies, metadata, err = app.models.Movies.GetSimilar(id, input.Fields, input.Filters)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+
+		app.similarCache.Set(key, movies, metadata)
+	}
+
+	if wantsField(input.Fields, "credits") {
+		err = app.loadCredits(movies...)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	if wantsField(input.Fields, "images") {
+		err = app.loadImages(movies...)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	if wantsField(input.Fields, "title") {
+		err = app.localizeTitles(input.Languages, movies...)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	picked := make([]interface{}, len(movies))
+	for i, movie := range movies {
+		movie.RuntimeFormat = input.RuntimeFormat
+		picked[i] = pickFields(movie, input.Fields)
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"movies": picked, "metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// readLanguages returns the languages the client wants titles in, most
+// preferred first. The lang query string parameter takes precedence over the
+// Accept-Language header. Tags in the header that can't be used are skipped,
+// as are wildcards, since the original title is always the fallback anyway.
+func (app *application) readLanguages(request *http.Request, v *validator.Validator) []string {
+
+	if lang := request.URL.Query().Get("lang"); lang != "" {
+		tag, ok := data.CanonicalLanguageTag(lang)
+		v.Check(ok, "lang", "must be a BCP 47 language tag such as de or pt-BR")
+		return []string{tag}
+	}
+
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var preferences []weighted
+
+	for _, part := range strings.Split(request.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		tag, ok := data.CanonicalLanguageTag(strings.TrimSpace(tag))
+		if !ok {
+			continue
+		}
+
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		if q > 0 {
+			preferences = append(preferences, weighted{tag, q})
+		}
+	}
+
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].q > preferences[j].q
+	})
+
+	languages := make([]string, len(preferences))
+	for i, preference := range preferences {
+		languages[i] = preference.tag
+	}
+
+	return languages
+}
+
+// localizeTitles replaces the title of each movie with its alternate title in
+// the most preferred of languages that it has one for, keeping the original
+// in OriginalTitle. Movies without a matching alternate title are left as
+// they are.
+func (app *application) localizeTitles(languages []string, movies ...*data.Movie) error {
+
+	if len(languages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	titles, err := app.models.Titles.GetBestForMovies(ids, data.LanguageFallbacks(languages))
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		if title, ok := titles[movie.ID]; ok && title.Title != movie.Title {
+			movie.OriginalTitle = movie.Title
+			movie.Title = title.Title
+		}
+	}
+
+	return nil
+}
+
+func (app *application) listMovieTitlesHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	titles, err := app.models.Titles.GetAllForMovie(id)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"titles": titles}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// setMovieTitleHandler adds or replaces the title of a movie in the language
+// given in the URL.
+func (app *application) setMovieTitleHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	var input struct {
+		Title string `json:"title"`
+	}
+
+	err = app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	title := &data.AlternateTitle{
+		MovieID:  id,
+		Language: httprouter.ParamsFromContext(request.Context()).ByName("language"),
+		Title:    input.Title,
+	}
+
+	v := validator.New()
+
+	if data.ValidateAlternateTitle(v, title); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	title.Language, _ = data.CanonicalLanguageTag(title.Language)
+
+	err = app.models.Titles.Set(title, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"title": title}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) removeMovieTitleHandler(response http.ResponseWriter, request *http.Request) {
+
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	language, ok := data.CanonicalLanguageTag(httprouter.ParamsFromContext(request.Context()).ByName("language"))
+	if !ok {
+		app.notFoundResponse(response, request)
+		return
+	}
+
+	err = app.models.Titles.Remove(id, language, app.contextGetUser(request).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"message": "title successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
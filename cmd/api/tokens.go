@@ -3,12 +3,25 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Emmanuel-MacAnThony/greenlight/internal/data"
 	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+	"github.com/tomasen/realip"
 )
 
+// sessionUserAgent returns the user agent to record against a session. It's
+// only there to help users recognise their sessions, so there's no need to
+// store an arbitrarily long one.
+func sessionUserAgent(request *http.Request) string {
+	userAgent := request.UserAgent()
+	if len(userAgent) > 500 {
+		userAgent = strings.ToValidUTF8(userAgent[:500], "")
+	}
+	return userAgent
+}
+
 func (app *application) createAuthenticationTokenHandler(response http.ResponseWriter, request *http.Request) {
 
 	// parse the email and password from the request body
@@ -57,15 +70,199 @@ func (app *application) createAuthenticationTokenHandler(response http.ResponseW
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	access, refresh, err := app.models.Tokens.NewSession(user.ID, app.config.auth.accessTTL, app.config.auth.refreshTTL, realip.FromRequest(request), sessionUserAgent(request))
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+
+}
+
+// createPasswordResetTokenHandler emails a password reset token to the user
+// with the given email address. The response is the same whether or not
+// there is such a user, so it can't be used to find out who has an account.
+func (app *application) createPasswordResetTokenHandler(response http.ResponseWriter, request *http.Request) {
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	if user != nil {
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+
+		app.background(func() {
+
+			data := map[string]interface{}{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl.html", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	env := envelope{"message": "if an account exists for this email address, you will be sent password reset instructions"}
+
+	err = app.writeJSON(response, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// createActivationTokenHandler emails a new activation token to a user who
+// hasn't activated their account yet, replacing any they were sent before.
+// Like password resets, the response doesn't say whether anything was sent.
+func (app *application) createActivationTokenHandler(response http.ResponseWriter, request *http.Request) {
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	// Unlike the IP limiter this always applies, since it protects the
+	// recipient rather than the server.
+	if !app.activationLimiter.Allow(strings.ToLower(input.Email)) {
+		app.rateLimitExceededResponse(response, request)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	if user != nil && !user.Activated {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+
+		app.background(func() {
+
+			data := map[string]interface{}{
+				"activationToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_activation.tmpl.html", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	env := envelope{"message": "if this email address belongs to an account awaiting activation, you will be sent activation instructions"}
+
+	err = app.writeJSON(response, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// deleteAuthenticationTokenHandler logs out by revoking the bearer token the
+// request was made with, along with the refresh tokens issued with it.
+func (app *application) deleteAuthenticationTokenHandler(response http.ResponseWriter, request *http.Request) {
+
+	err := app.models.Tokens.Revoke(app.contextGetToken(request))
 	if err != nil {
 		app.serverErrorResponse(response, request, err)
 		return
 	}
 
-	err = app.writeJSON(response, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(response, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
 	if err != nil {
 		app.serverErrorResponse(response, request, err)
 	}
+}
+
+// refreshAuthenticationTokenHandler exchanges a refresh token for a new
+// authentication token and a new refresh token. Each refresh token can only
+// be exchanged once. Presenting one a second time suggests it was stolen, so
+// every token from that login is revoked.
+func (app *application) refreshAuthenticationTokenHandler(response http.ResponseWriter, request *http.Request) {
+
+	var input struct {
+		TokenPlaintext string `json:"refresh_token"`
+	}
 
+	err := app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	access, refresh, err := app.models.Tokens.Rotate(input.TokenPlaintext, app.config.auth.accessTTL, app.config.auth.refreshTTL, realip.FromRequest(request), sessionUserAgent(request))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("refresh_token", "invalid or expired refresh token")
+			app.failedValidationResponse(response, request, v.Errors)
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			app.refreshTokenReusedResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
 }
@@ -64,6 +64,14 @@ func (app *application) registerUserHandler(response http.ResponseWriter, reques
 		return
 	}
 
+	// Every user has a watchlist from the start, so it can be used without
+	// looking up their lists first.
+	err = app.models.Lists.EnsureDefault(user.ID)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
 	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(response, request, err)
@@ -151,6 +159,292 @@ func (app *application) activateUserHandler(response http.ResponseWriter, reques
 
 }
 
+// updateUserPasswordHandler sets a new password for the user a password
+// reset token was issued to. Every token the user holds is then deleted,
+// which signs them out everywhere and stops the reset token being reused.
+func (app *application) updateUserPasswordHandler(response http.ResponseWriter, request *http.Request) {
+
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlainText(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(response, request, v.Errors)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllScopesForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+		return
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+func (app *application) showCurrentUserHandler(response http.ResponseWriter, request *http.Request) {
+
+	user := app.contextGetUser(request)
+
+	err := app.writeJSON(response, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// updateCurrentUserHandler lets users change their own name, password and
+// email address. Changing the password or email requires the current
+// password. A new email address is only held as pending, and a token is sent
+// to it which confirmEmailChangeHandler exchanges for the actual change.
+func (app *application) updateCurrentUserHandler(response http.ResponseWriter, request *http.Request) {
+
+	user := app.contextGetUser(request)
+
+	var input struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword *string `json:"current_password"`
+	}
+
+	err := app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	// Asking for the address the user already has cancels a pending change.
+	emailChanged := input.Email != nil && *input.Email != user.Email
+
+	if input.Password != nil || emailChanged {
+		v := validator.New()
+
+		if input.CurrentPassword == nil {
+			v.AddError("current_password", "must be provided to change the password or email address")
+			app.failedValidationResponse(response, request, v.Errors)
+			return
+		}
+
+		match, err := user.Password.Matches(*input.CurrentPassword)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+
+		if !match {
+			v.AddError("current_password", "is incorrect")
+			app.failedValidationResponse(response, request, v.Errors)
+			return
+		}
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if input.Password != nil {
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	if input.Email != nil {
+		data.ValidateEmail(v, *input.Email)
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	if input.Email != nil {
+		if emailChanged {
+			user.PendingEmail = input.Email
+		} else {
+			user.PendingEmail = nil
+		}
+	}
+
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	// A password change is often a response to the account being
+	// compromised, so it signs out every other session and voids any
+	// outstanding reset tokens.
+	if input.Password != nil {
+		_, err = app.models.Tokens.RevokeOtherSessions(user.ID, app.contextGetToken(request))
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+
+		err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	if input.Email != nil {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	if emailChanged {
+		token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+
+		pendingEmail := *user.PendingEmail
+
+		app.background(func() {
+
+			data := map[string]interface{}{
+				"emailChangeToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(pendingEmail, "token_email_change.tmpl.html", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
+// confirmEmailChangeHandler makes a user's pending email address their
+// email address, given the token that was sent to it.
+func (app *application) confirmEmailChangeHandler(response http.ResponseWriter, request *http.Request) {
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(response, request, &input)
+	if err != nil {
+		app.badRequestResponse(response, request, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(response, request, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err == nil && user.PendingEmail == nil {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(response, request, v.Errors)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	user.Email = *user.PendingEmail
+	user.PendingEmail = nil
+
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(response, request, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(response, request)
+		default:
+			app.serverErrorResponse(response, request, err)
+		}
+		return
+	}
+
+	// Password reset tokens were sent to the old address, so they go too.
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopePasswordReset} {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(response, request, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(response, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(response, request, err)
+	}
+}
+
 // {"name": "Alice Smith", "email": "alice@example.com", "password": "pa55word"}
 // {"name": "Bob Jones", "email": "bob@example.com", "password": "pa55word"}
 //{"name": "Carol Smith", "email": "carol@example.com", "password": "pa55word"}
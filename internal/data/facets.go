@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+)
+
+var MovieFacets = []string{"genres", "year"}
+
+// Facets summarises every movie matching a query, not just the current page.
+// Only the facets that were asked for are set.
+type Facets struct {
+	Genres []GenreCount  `json:"genres,omitempty"`
+	Year   []DecadeCount `json:"year,omitempty"`
+}
+
+type GenreCount struct {
+	Genre string `json:"genre"`
+	Count int    `json:"count"`
+}
+
+// DecadeCount counts the movies released in the ten years starting with
+// Decade, e.g. 1990 covers 1990 to 1999.
+type DecadeCount struct {
+	Decade int32 `json:"decade"`
+	Count  int   `json:"count"`
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx, so the same read can run
+// on its own or as part of a transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func ValidateMovieFacets(v *validator.Validator, facets []string) {
+	for _, facet := range facets {
+		v.Check(validator.In(facet, MovieFacets...), "facets", "must only contain genres or year")
+	}
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+}
+
+func getMovieFacets(ctx context.Context, db queryer, q MovieQuery, facets []string) (*Facets, error) {
+
+	counts := &Facets{}
+
+	where, _, args := q.where(nil)
+
+	for _, facet := range facets {
+		switch facet {
+		case "genres":
+			query := fmt.Sprintf(`
+						SELECT genre, count(*)
+						FROM movies, unnest(genres) AS genre
+						WHERE %s
+						GROUP BY genre
+						ORDER BY count(*) DESC, genre ASC`, where)
+
+			rows, err := db.QueryContext(ctx, query, args...)
+			if err != nil {
+				return nil, err
+			}
+
+			counts.Genres = []GenreCount{}
+
+			for rows.Next() {
+				var c GenreCount
+
+				err = rows.Scan(&c.Genre, &c.Count)
+				if err != nil {
+					rows.Close()
+					return nil, err
+				}
+
+				counts.Genres = append(counts.Genres, c)
+			}
+
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return nil, err
+			}
+
+		case "year":
+			query := fmt.Sprintf(`
+						SELECT year / 10 * 10 AS decade, count(*)
+						FROM movies
+						WHERE %s
+						GROUP BY decade
+						ORDER BY decade ASC`, where)
+
+			rows, err := db.QueryContext(ctx, query, args...)
+			if err != nil {
+				return nil, err
+			}
+
+			counts.Year = []DecadeCount{}
+
+			for rows.Next() {
+				var c DecadeCount
+
+				err = rows.Scan(&c.Decade, &c.Count)
+				if err != nil {
+					rows.Close()
+					return nil, err
+				}
+
+				counts.Year = append(counts.Year, c)
+			}
+
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return counts, nil
+}
@@ -1,6 +1,9 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"math"
 	"strings"
 
@@ -12,14 +15,55 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// SortColumns maps sort keys to column names where the two differ.
+	SortColumns map[string]string
+	// CursorMode switches GetAll from page/offset to keyset pagination. An
+	// empty Cursor in cursor mode requests the first page.
+	CursorMode bool
+	Cursor     string
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor identifies the row a keyset page starts after (or before, when
+// Before is set). Value holds the sort column value of that row and ID breaks
+// ties between rows sharing the same value.
+type cursor struct {
+	Sort   string `json:"s"`
+	Value  string `json:"v"`
+	ID     int64  `json:"id"`
+	Before bool   `json:"b,omitempty"`
+}
+
+func (c cursor) encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.ID < 1 {
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -40,7 +84,11 @@ func (f Filters) sortColumn() string {
 	for _, safeValue := range f.SortSafelist {
 
 		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+			column := strings.TrimPrefix(f.Sort, "-")
+			if mapped, ok := f.SortColumns[column]; ok {
+				return mapped
+			}
+			return column
 		}
 	}
 
@@ -55,6 +103,42 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+// cursor returns the decoded cursor for the request. The zero cursor is
+// returned when no cursor was supplied, i.e. for the first page.
+func (f Filters) cursor() (cursor, error) {
+	if f.Cursor == "" {
+		return cursor{Sort: f.Sort}, nil
+	}
+	return decodeCursor(f.Cursor)
+}
+
+func calculateCursorMetadata(pageSize int, next, prev *cursor) Metadata {
+	metadata := Metadata{PageSize: pageSize}
+	if next != nil {
+		metadata.NextCursor = next.encode()
+	}
+	if prev != nil {
+		metadata.PrevCursor = prev.encode()
+	}
+	return metadata
+}
+
+func reverseDirection(direction string) string {
+	if direction == "ASC" {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// comparisonOperator returns the operator that selects rows coming after a
+// given value when ordering in direction.
+func comparisonOperator(direction string) string {
+	if direction == "ASC" {
+		return ">"
+	}
+	return "<"
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -72,4 +156,12 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
 
+	if f.CursorMode {
+		v.Check(f.Page == 1, "page", "must not be used together with cursor")
+
+		c, err := f.cursor()
+		v.Check(err == nil, "cursor", "must be a valid cursor")
+		v.Check(err != nil || c.Sort == f.Sort, "cursor", "was issued for a different sort value")
+	}
+
 }
@@ -0,0 +1,474 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+
+	// Slugs keep letters from any script, so that genres like "Comédie" or
+	// "Аниме" aren't mangled, but they must be lowercase.
+	SlugRX = regexp.MustCompile(`^[\p{Ll}\p{Lm}\p{Lo}\p{N}]+(-[\p{Ll}\p{Lm}\p{Lo}\p{N}]+)*$`)
+
+	nonSlugRX = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+)
+
+// Genre is an entry in the genre vocabulary. Movies store genres by slug.
+// Aliases are other slugs that resolve to this genre when a movie is written,
+// such as former slugs and genres merged into this one.
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	Version   int32     `json:"version"`
+}
+
+// GenreVocabulary maps every known slug and alias to the slug of the genre it
+// names.
+type GenreVocabulary map[string]string
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// Slugify turns a genre as people write it into slug form, e.g. "Sci-Fi" and
+// "sci fi" both become "sci-fi", and "Comédie" becomes "comédie". It matches
+// the normalisation applied to existing movies when the genres table was
+// created.
+func Slugify(s string) string {
+	return strings.Trim(nonSlugRX.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// Resolve returns genres with each one replaced by the slug of the genre it
+// names. Unknown genres are returned as given, for ValidateMovie to report.
+// Genres resolving to the same slug are only returned once.
+func (vocab GenreVocabulary) Resolve(genres []string) []string {
+
+	if genres == nil {
+		return nil
+	}
+
+	resolved := make([]string, 0, len(genres))
+
+	for _, genre := range genres {
+		genre = vocab.Lookup(genre)
+		if !validator.In(genre, resolved...) {
+			resolved = append(resolved, genre)
+		}
+	}
+
+	return resolved
+}
+
+// Lookup returns the slug of the genre that genre names, or genre itself if
+// it names none.
+func (vocab GenreVocabulary) Lookup(genre string) string {
+	if slug, ok := vocab[Slugify(genre)]; ok {
+		return slug
+	}
+	return genre
+}
+
+// Known reports whether genre is the slug of a genre, rather than an alias or
+// something else entirely.
+func (vocab GenreVocabulary) Known(genre string) bool {
+	return vocab[genre] == genre
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(len(genre.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(genre.Slug, SlugRX), "slug", "must only contain lowercase letters, digits and single hyphens")
+
+	for _, alias := range genre.Aliases {
+		v.Check(validator.Matches(alias, SlugRX), "aliases", "must only contain lowercase letters, digits and single hyphens")
+	}
+	v.Check(validator.Unique(genre.Aliases), "aliases", "must not contain duplicate values")
+	v.Check(!validator.In(genre.Slug, genre.Aliases...), "aliases", "must not contain the genre's own slug")
+}
+
+// lockGenres serialises changes to the vocabulary. Slugs and aliases share
+// one namespace across two tables, which no single constraint can enforce,
+// so writers check for clashes while holding this lock. Readers are not
+// blocked.
+func lockGenres(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `LOCK TABLE genres IN SHARE ROW EXCLUSIVE MODE`)
+	return err
+}
+
+// saveAliases replaces the aliases of a genre, making sure none of its slug
+// or aliases is already taken by another genre.
+func saveAliases(ctx context.Context, tx *sql.Tx, genre *Genre) error {
+
+	var taken bool
+
+	query := `
+			SELECT EXISTS (SELECT 1 FROM genres WHERE slug = ANY($1) AND id <> $2)
+				OR EXISTS (SELECT 1 FROM genre_aliases WHERE alias = ANY($3) AND genre_id <> $2)`
+
+	names := append([]string{genre.Slug}, genre.Aliases...)
+
+	err := tx.QueryRowContext(ctx, query, pq.Array(genre.Aliases), genre.ID, pq.Array(names)).Scan(&taken)
+	if err != nil {
+		return err
+	}
+
+	if taken {
+		return ErrDuplicateGenre
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM genre_aliases WHERE genre_id = $1`, genre.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+			INSERT INTO genre_aliases (alias, genre_id)
+			SELECT unnest($1::text[]), $2`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(genre.Aliases), genre.ID)
+	return err
+}
+
+func (m GenreModel) Insert(genre *Genre) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockGenres(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO genres (slug, name)
+			VALUES ($1, $2)
+			RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, genre.Slug, genre.Name).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_slug_key"`:
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	err = saveAliases(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+			SELECT genres.id, genres.created_at, genres.slug, genres.name, genres.version,
+				COALESCE(array_agg(genre_aliases.alias ORDER BY genre_aliases.alias) FILTER (WHERE genre_aliases.alias IS NOT NULL), '{}')
+			FROM genres
+			LEFT JOIN genre_aliases ON genre_aliases.genre_id = genres.id
+			WHERE genres.id = $1
+			GROUP BY genres.id`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Slug,
+		&genre.Name,
+		&genre.Version,
+		pq.Array(&genre.Aliases),
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+// GetAll returns the whole vocabulary ordered by name. It is small enough
+// that it isn't paginated.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+
+	query := `
+			SELECT genres.id, genres.created_at, genres.slug, genres.name, genres.version,
+				COALESCE(array_agg(genre_aliases.alias ORDER BY genre_aliases.alias) FILTER (WHERE genre_aliases.alias IS NOT NULL), '{}')
+			FROM genres
+			LEFT JOIN genre_aliases ON genre_aliases.genre_id = genres.id
+			GROUP BY genres.id
+			ORDER BY genres.name ASC, genres.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(
+			&genre.ID,
+			&genre.CreatedAt,
+			&genre.Slug,
+			&genre.Name,
+			&genre.Version,
+			pq.Array(&genre.Aliases),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// Vocabulary returns every slug and alias in the vocabulary, for resolving
+// and validating the genres of movies being written.
+func (m GenreModel) Vocabulary() (GenreVocabulary, error) {
+
+	query := `
+			SELECT slug, slug FROM genres
+			UNION ALL
+			SELECT genre_aliases.alias, genres.slug
+			FROM genre_aliases
+			INNER JOIN genres ON genres.id = genre_aliases.genre_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vocab := GenreVocabulary{}
+
+	for rows.Next() {
+		var name, slug string
+
+		err := rows.Scan(&name, &slug)
+		if err != nil {
+			return nil, err
+		}
+
+		vocab[name] = slug
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return vocab, nil
+}
+
+// Update renames a genre, provided nobody else has updated it since it was
+// read. When the slug changes, movies are moved over to the new slug and the
+// old one is kept as an alias, so clients still sending it keep working. The
+// movies' revisions are attributed to userID.
+func (m GenreModel) Update(genre *Genre, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockGenres(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	var oldSlug string
+
+	err = tx.QueryRowContext(ctx, `SELECT slug FROM genres WHERE id = $1`, genre.ID).Scan(&oldSlug)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if oldSlug != genre.Slug && !validator.In(oldSlug, genre.Aliases...) {
+		genre.Aliases = append(genre.Aliases, oldSlug)
+	}
+
+	query := `
+			UPDATE genres
+			SET slug = $1, name = $2, version = version + 1
+			WHERE id = $3 AND version = $4
+			RETURNING version`
+
+	args := []interface{}{genre.Slug, genre.Name, genre.ID, genre.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_slug_key"`:
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	err = saveAliases(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	if oldSlug != genre.Slug {
+		err = replaceMovieGenre(ctx, tx, oldSlug, genre.Slug, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Merge folds the genre source into target. Movies tagged with source are
+// retagged with target, and source's slug and aliases become aliases of
+// target. The updated target is returned. The retagged movies' revisions are
+// attributed to userID.
+func (m GenreModel) Merge(sourceID, targetID, userID int64) (*Genre, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockGenres(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+			SELECT (SELECT slug FROM genres WHERE id = $1), (SELECT slug FROM genres WHERE id = $2)`
+
+	var sourceSlug, targetSlug sql.NullString
+
+	err = tx.QueryRowContext(ctx, query, sourceID, targetID).Scan(&sourceSlug, &targetSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sourceSlug.Valid || !targetSlug.Valid {
+		return nil, ErrRecordNotFound
+	}
+
+	err = replaceMovieGenre(ctx, tx, sourceSlug.String, targetSlug.String, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE genre_aliases SET genre_id = $1 WHERE genre_id = $2`, targetID, sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1`, sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO genre_aliases (alias, genre_id) VALUES ($1, $2)`, sourceSlug.String, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE genres SET version = version + 1 WHERE id = $1`, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Get(targetID)
+}
+
+// replaceMovieGenre retags every movie tagged from with to, dropping the
+// duplicate if a movie already had both, and bumps the versions of the
+// movies it changes so their ETags change too. Their previous genres are
+// recorded as revisions first.
+func replaceMovieGenre(ctx context.Context, tx *sql.Tx, from, to string, userID int64) error {
+	movieIDs, err := recordMovieRevisions(ctx, tx, RevisionGenres, userID, `genres @> ARRAY[$1::text]`, from)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			UPDATE movies
+			SET genres = ARRAY(
+					SELECT t.genre
+					FROM unnest(array_replace(movies.genres, $1::text, $2::text)) WITH ORDINALITY AS t(genre, n)
+					GROUP BY t.genre
+					ORDER BY min(t.n)
+				),
+				version = version + 1
+			WHERE id = ANY($3)`
+
+	_, err = tx.ExecContext(ctx, query, from, to, pq.Array(movieIDs))
+	return err
+}
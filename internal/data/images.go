@@ -0,0 +1,242 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/storage"
+	"github.com/lib/pq"
+)
+
+const (
+	ImagePoster   = "poster"
+	ImageBackdrop = "backdrop"
+)
+
+var ImageKinds = []string{ImagePoster, ImageBackdrop}
+
+// Image is a picture of a movie, stored in a storage.Storage along with a
+// JPEG thumbnail. A movie has at most one image of each kind.
+type Image struct {
+	MovieID      int64     `json:"-"`
+	Kind         string    `json:"-"`
+	Key          string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	ContentType  string    `json:"content_type"`
+	Width        int32     `json:"width"`
+	Height       int32     `json:"height"`
+	Size         int64     `json:"size"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// MovieImageModel records which stored files belong to which movie. Files
+// are stored by the caller before Set is called, but once a file is no
+// longer referenced the model deletes it from Storage itself.
+type MovieImageModel struct {
+	DB      *sql.DB
+	Storage storage.Storage
+}
+
+// deleteFiles removes files that are no longer referenced. It runs after the
+// change that unreferenced them has committed, so a failure here only leaves
+// an orphaned file behind, and is deliberately not reported.
+func deleteFiles(store storage.Storage, keys ...string) {
+
+	if store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, key := range keys {
+		_ = store.Delete(ctx, key)
+	}
+}
+
+func (m MovieImageModel) setURLs(image *Image) {
+	image.URL = m.Storage.URL(image.Key)
+	image.ThumbnailURL = m.Storage.URL(image.ThumbnailKey)
+}
+
+// Set records image as the movie's image of its kind, replacing and deleting
+// any previous one. The movie's version is bumped, since its images are part
+// of its representation, and a revision attributed to userID is recorded.
+func (m MovieImageModel) Set(image *Image, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, image.MovieID)
+	if err != nil {
+		return err
+	}
+
+	var oldKeys []string
+
+	err = tx.QueryRowContext(ctx, `SELECT ARRAY[key, thumbnail_key] FROM movie_images WHERE movie_id = $1 AND kind = $2`,
+		image.MovieID, image.Kind).Scan(pq.Array(&oldKeys))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	query := `
+			INSERT INTO movie_images (movie_id, kind, key, thumbnail_key, content_type, width, height, size)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (movie_id, kind)
+			DO UPDATE SET key = EXCLUDED.key, thumbnail_key = EXCLUDED.thumbnail_key, content_type = EXCLUDED.content_type,
+				width = EXCLUDED.width, height = EXCLUDED.height, size = EXCLUDED.size, created_at = NOW()
+			RETURNING created_at`
+
+	args := []interface{}{
+		image.MovieID,
+		image.Kind,
+		image.Key,
+		image.ThumbnailKey,
+		image.ContentType,
+		image.Width,
+		image.Height,
+		image.Size,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&image.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = recordMovieRevisions(ctx, tx, RevisionImages, userID, `id = $1`, image.MovieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET version = version + 1 WHERE id = $1`, image.MovieID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	deleteFiles(m.Storage, oldKeys...)
+	m.setURLs(image)
+
+	return nil
+}
+
+func (m MovieImageModel) Remove(movieID int64, kind string, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+
+	err = tx.QueryRowContext(ctx, `DELETE FROM movie_images WHERE movie_id = $1 AND kind = $2 RETURNING ARRAY[key, thumbnail_key]`,
+		movieID, kind).Scan(pq.Array(&keys))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = recordMovieRevisions(ctx, tx, RevisionImages, userID, `id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET version = version + 1 WHERE id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	deleteFiles(m.Storage, keys...)
+
+	return nil
+}
+
+// GetForMovies returns the images of each of the given movies using a single
+// query, keyed by movie ID and then by kind.
+func (m MovieImageModel) GetForMovies(movieIDs []int64) (map[int64]map[string]*Image, error) {
+
+	images := make(map[int64]map[string]*Image, len(movieIDs))
+
+	if len(movieIDs) == 0 {
+		return images, nil
+	}
+
+	query := `
+			SELECT movie_id, kind, key, thumbnail_key, content_type, width, height, size, created_at
+			FROM movie_images
+			WHERE movie_id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var image Image
+
+		err := rows.Scan(
+			&image.MovieID,
+			&image.Kind,
+			&image.Key,
+			&image.ThumbnailKey,
+			&image.ContentType,
+			&image.Width,
+			&image.Height,
+			&image.Size,
+			&image.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		m.setURLs(&image)
+
+		if images[image.MovieID] == nil {
+			images[image.MovieID] = map[string]*Image{}
+		}
+		images[image.MovieID][image.Kind] = &image
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
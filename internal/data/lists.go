@@ -0,0 +1,524 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	VisibilityPrivate  = "private"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPublic   = "public"
+
+	// DefaultListName is the name of the list every user starts with.
+	DefaultListName = "watchlist"
+)
+
+var (
+	ErrDuplicateListName  = errors.New("duplicate list name")
+	ErrDuplicateListEntry = errors.New("duplicate list entry")
+)
+
+// List is a user's ordered collection of movies. Private lists are only
+// visible to their owner, unlisted lists to anyone who knows their share
+// token, and public lists to anyone, including in the public listing. IDs
+// are sequential, so they are never enough to read an unlisted list.
+type List struct {
+	ID          int64        `json:"id"`
+	ShareToken  string       `json:"share_token,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UserID      int64        `json:"user_id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Visibility  string       `json:"visibility"`
+	IsDefault   bool         `json:"is_default"`
+	Version     int32        `json:"version"`
+	Entries     []*ListEntry `json:"entries,omitempty"`
+}
+
+// ListEntry is a movie on a list. Positions start at 1 and are kept
+// contiguous as entries are added, moved and removed, and when a movie is
+// purged. A movie in the trash keeps its position, so that it comes back to
+// the same place if restored; until then it is left out of the entries and
+// its position is skipped.
+type ListEntry struct {
+	ListID   int64     `json:"-"`
+	MovieID  int64     `json:"movie_id"`
+	Position int32     `json:"position"`
+	Note     string    `json:"note,omitempty"`
+	AddedAt  time.Time `json:"added_at"`
+	Title    string    `json:"title"`
+	Year     int32     `json:"year,omitempty"`
+}
+
+type ListModel struct {
+	DB *sql.DB
+}
+
+// Visible reports whether user may read the list when it is looked up by
+// ID. Unlisted lists are only visible this way to their owner.
+func (l *List) Visible(user *User) bool {
+	return l.Visibility == VisibilityPublic || l.UserID == user.ID
+}
+
+// VisibleByShareToken reports whether user may read the list when it is
+// looked up by its share token, which is the point of unlisted lists.
+func (l *List) VisibleByShareToken(user *User) bool {
+	return l.Visibility != VisibilityPrivate || l.UserID == user.ID
+}
+
+func ValidateList(v *validator.Validator, list *List) {
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(list.IsDefault || list.Name != DefaultListName, "name", fmt.Sprintf("%q is reserved for the default list", DefaultListName))
+	v.Check(len(list.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(list.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+	v.Check(validator.In(list.Visibility, VisibilityPrivate, VisibilityUnlisted, VisibilityPublic), "visibility", "must be private, unlisted or public")
+}
+
+func ValidateListEntry(v *validator.Validator, entry *ListEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", "must be provided")
+	v.Check(entry.Position >= 0, "position", "must not be negative")
+	v.Check(len(entry.Note) <= 2000, "note", "must not be more than 2000 bytes long")
+}
+
+// EnsureDefault creates the user's default watchlist if they don't have one
+// yet. It is called when a user registers, and is safe to call repeatedly. Only an existing default counts as a
+// conflict; ValidateList keeps other lists from taking its name.
+func (m ListModel) EnsureDefault(userID int64) error {
+	query := `
+			INSERT INTO lists (user_id, name, visibility, is_default)
+			VALUES ($1, $2, $3, true)
+			ON CONFLICT (user_id) WHERE is_default DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, DefaultListName, VisibilityPrivate)
+	return err
+}
+
+func (m ListModel) Insert(list *List) error {
+	query := `
+			INSERT INTO lists (user_id, name, description, visibility)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, share_token, created_at, version`
+
+	args := []interface{}{list.UserID, list.Name, list.Description, list.Visibility}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.ID, &list.ShareToken, &list.CreatedAt, &list.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "lists_user_id_name_key"`:
+			return ErrDuplicateListName
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ListModel) Get(id int64) (*List, error) {
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.get("id = $1", id)
+}
+
+// GetByShareToken returns the list with the given share token.
+func (m ListModel) GetByShareToken(token string) (*List, error) {
+	return m.get("share_token = $1", token)
+}
+
+func (m ListModel) get(condition string, arg interface{}) (*List, error) {
+
+	query := `
+			SELECT id, share_token, created_at, user_id, name, description, visibility, is_default, version
+			FROM lists
+			WHERE ` + condition
+
+	var list List
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(
+		&list.ID,
+		&list.ShareToken,
+		&list.CreatedAt,
+		&list.UserID,
+		&list.Name,
+		&list.Description,
+		&list.Visibility,
+		&list.IsDefault,
+		&list.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &list, nil
+}
+
+func (m ListModel) Update(list *List) error {
+	query := `
+			UPDATE lists
+			SET name = $1, description = $2, visibility = $3, version = version + 1
+			WHERE id = $4 AND version = $5
+			RETURNING version`
+
+	args := []interface{}{list.Name, list.Description, list.Visibility, list.ID, list.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "lists_user_id_name_key"`:
+			return ErrDuplicateListName
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ListModel) Delete(id int64) error {
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+			DELETE FROM lists
+			WHERE id = $1 AND NOT is_default`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll returns lists ordered by filters. A userID of 0 matches every
+// user, and visibilities restricts the result to lists with one of the given
+// visibilities.
+func (m ListModel) GetAll(userID int64, visibilities []string, filters Filters) ([]*List, Metadata, error) {
+
+	query := fmt.Sprintf(`
+						SELECT count(*) OVER(), id, share_token, created_at, user_id, name, description, visibility, is_default, version
+						FROM lists
+						WHERE (user_id = $1 OR $1 = 0)
+						AND visibility = ANY($2)
+						ORDER BY is_default DESC, %s %s, id ASC
+						LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, pq.Array(visibilities), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	lists := []*List{}
+
+	for rows.Next() {
+
+		var list List
+
+		err := rows.Scan(
+			&totalRecords,
+			&list.ID,
+			&list.ShareToken,
+			&list.CreatedAt,
+			&list.UserID,
+			&list.Name,
+			&list.Description,
+			&list.Visibility,
+			&list.IsDefault,
+			&list.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		lists = append(lists, &list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return lists, metadata, nil
+}
+
+// GetEntries returns the entries of a list in position order. Movies that
+// are in the trash are skipped.
+func (m ListModel) GetEntries(listID int64) ([]*ListEntry, error) {
+
+	query := `
+			SELECT list_entries.list_id, list_entries.movie_id, list_entries.position, list_entries.note,
+				list_entries.added_at, movies.title, movies.year
+			FROM list_entries
+			INNER JOIN movies ON movies.id = list_entries.movie_id
+			WHERE list_entries.list_id = $1 AND movies.deleted_at IS NULL
+			ORDER BY list_entries.position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*ListEntry{}
+
+	for rows.Next() {
+		var entry ListEntry
+
+		err := rows.Scan(
+			&entry.ListID,
+			&entry.MovieID,
+			&entry.Position,
+			&entry.Note,
+			&entry.AddedAt,
+			&entry.Title,
+			&entry.Year,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// lockList takes a row lock on a list while its entries are renumbered, so
+// that concurrent changes to the same list can't interleave and leave gaps or
+// duplicate positions. It returns the number of entries on the list.
+func lockList(ctx context.Context, tx *sql.Tx, listID int64) (int32, error) {
+	_, err := tx.ExecContext(ctx, `SELECT id FROM lists WHERE id = $1 FOR UPDATE`, listID)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int32
+
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM list_entries WHERE list_id = $1`, listID).Scan(&count)
+	return count, err
+}
+
+// AddEntry inserts a movie into a list at entry.Position, shifting later
+// entries down. A zero or out of range position appends it to the end.
+func (m ListModel) AddEntry(entry *ListEntry) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	count, err := lockList(ctx, tx, entry.ListID)
+	if err != nil {
+		return err
+	}
+
+	if entry.Position < 1 || entry.Position > count+1 {
+		entry.Position = count + 1
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			UPDATE list_entries
+			SET position = position + 1
+			WHERE list_id = $1 AND position >= $2`, entry.ListID, entry.Position)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO list_entries (list_id, movie_id, position, note)
+			SELECT $1, id, $3, $4
+			FROM movies
+			WHERE id = $2 AND deleted_at IS NULL
+			RETURNING added_at`
+
+	args := []interface{}{entry.ListID, entry.MovieID, entry.Position, entry.Note}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&entry.AddedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case strings.HasPrefix(err.Error(), "pq: duplicate key value violates unique constraint"):
+			return ErrDuplicateListEntry
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateEntry changes the note of an entry and moves it to entry.Position,
+// shifting the entries in between. A zero position leaves it where it is.
+func (m ListModel) UpdateEntry(entry *ListEntry) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	count, err := lockList(ctx, tx, entry.ListID)
+	if err != nil {
+		return err
+	}
+
+	var current int32
+
+	err = tx.QueryRowContext(ctx, `
+			SELECT position FROM list_entries
+			WHERE list_id = $1 AND movie_id = $2`, entry.ListID, entry.MovieID).Scan(&current)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if entry.Position < 1 {
+		entry.Position = current
+	}
+	if entry.Position > count {
+		entry.Position = count
+	}
+
+	switch {
+	case entry.Position < current:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE list_entries
+			SET position = position + 1
+			WHERE list_id = $1 AND position >= $2 AND position < $3`, entry.ListID, entry.Position, current)
+	case entry.Position > current:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE list_entries
+			SET position = position - 1
+			WHERE list_id = $1 AND position > $2 AND position <= $3`, entry.ListID, current, entry.Position)
+	}
+	if err != nil {
+		return err
+	}
+
+	query := `
+			UPDATE list_entries
+			SET position = $1, note = $2
+			WHERE list_id = $3 AND movie_id = $4
+			RETURNING added_at`
+
+	err = tx.QueryRowContext(ctx, query, entry.Position, entry.Note, entry.ListID, entry.MovieID).Scan(&entry.AddedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveEntry takes a movie off a list and closes the gap it leaves.
+func (m ListModel) RemoveEntry(listID, movieID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = lockList(ctx, tx, listID)
+	if err != nil {
+		return err
+	}
+
+	var position int32
+
+	err = tx.QueryRowContext(ctx, `
+			DELETE FROM list_entries
+			WHERE list_id = $1 AND movie_id = $2
+			RETURNING position`, listID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+			UPDATE list_entries
+			SET position = position - 1
+			WHERE list_id = $1 AND position > $2`, listID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
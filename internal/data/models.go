@@ -1,8 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/storage"
 )
 
 var (
@@ -13,21 +17,47 @@ var (
 type Models struct {
 	Movies interface {
 		Insert(movie *Movie) error
+		InsertMany(movies []*Movie, batchSize int) error
 		Get(id int64) (*Movie, error)
-		Update(movie *Movie) error
-		Delete(id int64) error
-		GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
+		GetFields(id int64, fields []string) (*Movie, error)
+		GetMany(ids []int64, fields []string) ([]*Movie, error)
+		GetSimilar(id int64, fields []string, filters Filters) ([]*Movie, Metadata, error)
+		SimilarityState() (string, error)
+		Update(movie *Movie, userID int64) error
+		Delete(id int64, version int32) error
+		GetAll(q MovieQuery, filters Filters) ([]*Movie, Metadata, error)
+		GetAllWithFacets(q MovieQuery, filters Filters, facets []string) ([]*Movie, Metadata, *Facets, error)
+		GetAllDeleted(filters Filters) ([]*Movie, Metadata, error)
+		Restore(id int64, userID int64) error
+		Purge(id int64) error
+		PurgeDeletedBefore(cutoff time.Time) (int64, error)
+		Export(ctx context.Context, q MovieQuery, filters Filters, fn func(*Movie) error) error
 	}
+	Revisions   MovieRevisionModel
+	People      PeopleModel
+	Genres      GenreModel
+	Titles      TitleModel
+	Images      MovieImageModel
+	Ratings     RatingModel
+	Lists       ListModel
 	Users       UserModel
 	Tokens      TokenModel
 	Permissions PermissionsModel
 }
 
-func NewModels(db *sql.DB) Models {
+func NewModels(db *sql.DB, store storage.Storage) Models {
 	return Models{
 		Movies: MovieModel{
-			DB: db,
+			DB:      db,
+			Storage: store,
 		},
+		Revisions:   MovieRevisionModel{DB: db},
+		People:      PeopleModel{DB: db},
+		Genres:      GenreModel{DB: db},
+		Titles:      TitleModel{DB: db},
+		Images:      MovieImageModel{DB: db, Storage: store},
+		Ratings:     RatingModel{DB: db},
+		Lists:       ListModel{DB: db},
 		Users:       UserModel{DB: db},
 		Tokens:      TokenModel{DB: db},
 		Permissions: PermissionsModel{DB: db},
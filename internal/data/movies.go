@@ -3,10 +3,17 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/storage"
 	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
 	"github.com/lib/pq"
 )
@@ -19,6 +26,51 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitempty"` // Add the omitempty directive
 	Genres    []string  `json:"genres,omitempty"`  // Add the omitempty directive
 	Version   int32     `json:"version"`
+	// AverageRating and RatingCount are maintained by RatingModel whenever
+	// a rating is written, rather than being aggregated on every read.
+	AverageRating float64    `json:"average_rating"`
+	RatingCount   int32      `json:"rating_count"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	Credits       []*Credit  `json:"credits,omitempty"`
+	// Relevance and Highlight are only set on listings searched by title.
+	// Highlight is whichever of the movie's titles matched the search best,
+	// HTML-escaped and with the matching words wrapped in <mark> tags, so it
+	// can be inserted into a page as it is. When an alternate title matched,
+	// it may differ from Title.
+	Relevance float64 `json:"relevance,omitempty"`
+	Highlight string  `json:"highlight,omitempty"`
+	// Similarity is only set on movies listed as similar to another.
+	Similarity float64 `json:"similarity,omitempty"`
+	// OriginalTitle is only set when Title has been replaced by an
+	// alternate title in the language the client asked for.
+	OriginalTitle string            `json:"original_title,omitempty"`
+	Images        map[string]*Image `json:"images,omitempty"`
+	// RuntimeFormat picks how Runtime is written when the movie is encoded
+	// as JSON. The zero value writes "<n> mins".
+	RuntimeFormat RuntimeFormat `json:"-"`
+}
+
+// MarshalJSON encodes the movie with its runtime in m.RuntimeFormat.
+func (m Movie) MarshalJSON() ([]byte, error) {
+
+	// movie has Movie's fields but not its methods, so encoding it doesn't
+	// recurse back into this method. Runtime shadows the embedded field.
+	type movie Movie
+
+	aux := struct {
+		movie
+		Runtime json.RawMessage `json:"runtime,omitempty"`
+	}{movie: movie(m)}
+
+	if m.Runtime != 0 {
+		runtime, err := m.Runtime.MarshalFormat(m.RuntimeFormat)
+		if err != nil {
+			return nil, err
+		}
+		aux.Runtime = runtime
+	}
+
+	return json.Marshal(aux)
 }
 
 type MockMovieModel struct{}
@@ -27,26 +79,79 @@ func (m MockMovieModel) Insert(movie *Movie) error {
 	// Mock the action...
 	return nil
 }
+func (m MockMovieModel) InsertMany(movies []*Movie, batchSize int) error {
+	// Mock the action...
+	return nil
+}
 func (m MockMovieModel) Get(id int64) (*Movie, error) {
 	// Mock the action...
 	return nil, nil
 }
-func (m MockMovieModel) Update(movie *Movie) error {
+func (m MockMovieModel) GetFields(id int64, fields []string) (*Movie, error) {
+	// Mock the action...
+	return nil, nil
+}
+func (m MockMovieModel) GetMany(ids []int64, fields []string) ([]*Movie, error) {
+	// Mock the action...
+	return nil, nil
+}
+func (m MockMovieModel) GetSimilar(id int64, fields []string, filters Filters) ([]*Movie, Metadata, error) {
+	// Mock the action...
+	return nil, Metadata{}, nil
+}
+func (m MockMovieModel) SimilarityState() (string, error) {
+	// Mock the action...
+	return "", nil
+}
+func (m MockMovieModel) Update(movie *Movie, userID int64) error {
+	// Mock the action...
+	return nil
+}
+func (m MockMovieModel) Delete(id int64, version int32) error {
+	// Mock the action...
+	return nil
+}
+
+func (m MockMovieModel) GetAllDeleted(filters Filters) ([]*Movie, Metadata, error) {
+	// mock the action
+	return nil, Metadata{}, nil
+}
+
+func (m MockMovieModel) Restore(id int64, userID int64) error {
+	// Mock the action...
+	return nil
+}
+
+func (m MockMovieModel) Purge(id int64) error {
 	// Mock the action...
 	return nil
 }
-func (m MockMovieModel) Delete(id int64) error {
+
+func (m MockMovieModel) PurgeDeletedBefore(cutoff time.Time) (int64, error) {
 	// Mock the action...
+	return 0, nil
+}
+
+func (m MockMovieModel) Export(ctx context.Context, q MovieQuery, filters Filters, fn func(*Movie) error) error {
+	// mock the action
 	return nil
 }
 
-func (m MockMovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MockMovieModel) GetAll(q MovieQuery, filters Filters) ([]*Movie, Metadata, error) {
 	// mock the action
 	return nil, Metadata{}, nil
 }
 
+func (m MockMovieModel) GetAllWithFacets(q MovieQuery, filters Filters, facets []string) ([]*Movie, Metadata, *Facets, error) {
+	// mock the action
+	return nil, Metadata{}, nil, nil
+}
+
 type MovieModel struct {
 	DB *sql.DB
+	// Storage holds the files of movie images, which are deleted when their
+	// movie is purged.
+	Storage storage.Storage
 }
 
 func (m MovieModel) Insert(movie *Movie) error {
@@ -63,31 +168,102 @@ func (m MovieModel) Insert(movie *Movie) error {
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
 
+// InsertMany inserts movies in multi-row INSERT statements of at most
+// batchSize rows each. All batches share one transaction, so either every
+// movie is inserted or none are.
+func (m MovieModel) InsertMany(movies []*Movie, batchSize int) error {
+	if batchSize < 1 {
+		return fmt.Errorf("invalid batch size %d", batchSize)
+	}
+
+	if len(movies) == 0 {
+		return nil
+	}
+
+	batches := (len(movies) + batchSize - 1) / batchSize
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(batches)*3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(movies); start += batchSize {
+		end := start + batchSize
+		if end > len(movies) {
+			end = len(movies)
+		}
+		batch := movies[start:end]
+
+		values := make([]string, len(batch))
+		args := make([]interface{}, 0, len(batch)*4)
+
+		for i, movie := range batch {
+			n := i * 4
+			values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4)
+			args = append(args, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres))
+		}
+
+		// Postgres returns the RETURNING rows of a multi-row INSERT in
+		// VALUES order, so they can be matched back up by position.
+		query := `
+			INSERT INTO movies (title, year, runtime, genres)
+			VALUES ` + strings.Join(values, ", ") + `
+			RETURNING id, created_at, version`
+
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		i := 0
+		for rows.Next() {
+			err = rows.Scan(&batch[i].ID, &batch[i].CreatedAt, &batch[i].Version)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			i++
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	return m.GetFields(id, nil)
+}
+
+// GetFields is Get reading only the columns behind the given fields, as
+// listed in MovieFields. A nil fields reads every column.
+func (m MovieModel) GetFields(id int64, fields []string) (*Movie, error) {
 
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `
-			SELECT  id, created_at, title, year, runtime, genres, version
+
+	columns := movieColumns(fields, "id")
+
+	query := fmt.Sprintf(`
+			SELECT %s
 			FROM movies
-			WHERE id = $1`
+			WHERE id = $1 AND deleted_at IS NULL`, strings.Join(columns, ", "))
 
 	var movie Movie
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(movie.scanTargets(columns)...)
 
 	if err != nil {
 		switch {
@@ -101,11 +277,96 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// GetMany returns the movies with the given IDs in the order the IDs were
+// given, using a single query. IDs without a movie, including those of
+// deleted movies, are left out.
+func (m MovieModel) GetMany(ids []int64, fields []string) ([]*Movie, error) {
+
+	movies := []*Movie{}
+
+	if len(ids) == 0 {
+		return movies, nil
+	}
+
+	columns := movieColumns(fields, "id")
+
+	query := fmt.Sprintf(`
+			SELECT %s
+			FROM movies
+			WHERE id = ANY($1) AND deleted_at IS NULL
+			ORDER BY array_position($1::bigint[], id)`, strings.Join(columns, ", "))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(movie.scanTargets(columns)...)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// Update saves the changes to movie, provided nobody else has updated it since
+// it was read. The values being replaced are kept as a revision attributed to
+// userID, in the same transaction as the update itself.
+func (m MovieModel) Update(movie *Movie, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the row first so that a concurrent update cannot slip in between
+	// recording the revision and applying the change.
 	query := `
+			INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, changed_by, reason)
+			SELECT id, version, title, year, runtime, genres, $3, $4
+			FROM (
+				SELECT id, version, title, year, runtime, genres
+				FROM movies
+				WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+				FOR UPDATE
+			) AS previous`
+
+	result, err := tx.ExecContext(ctx, query, movie.ID, movie.Version, userID, RevisionDetails)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	query = `
 			UPDATE movies
 			SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-			WHERE id = $5 AND version = $6
+			WHERE id = $5 AND version = $6 AND deleted_at IS NULL
 			RETURNING version`
 
 	args := []interface{}{
@@ -117,10 +378,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 
 	if err != nil {
 
@@ -133,24 +391,29 @@ func (m MovieModel) Update(movie *Movie) error {
 		}
 	}
 
-	return nil
+	return tx.Commit()
 
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Delete moves a movie to the trash by setting its deleted_at timestamp. The
+// row is kept until it is purged, either explicitly or by the retention job.
+// A non-zero version makes the delete conditional on the movie still being
+// at that version, and ErrEditConflict is returned if it isn't.
+func (m MovieModel) Delete(id int64, version int32) error {
 
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-			DELETE FROM movies
-			WHERE id = $1`
+			UPDATE movies
+			SET deleted_at = NOW()
+			WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, version)
 	if err != nil {
 		return err
 	}
@@ -161,27 +424,28 @@ func (m MovieModel) Delete(id int64) error {
 	}
 
 	if rowsAffected == 0 {
+		if version != 0 {
+			return ErrEditConflict
+		}
 		return ErrRecordNotFound
 	}
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// GetAllDeleted returns the movies currently in the trash.
+func (m MovieModel) GetAllDeleted(filters Filters) ([]*Movie, Metadata, error) {
 
 	query := fmt.Sprintf(`
-						SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+						SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, average_rating, rating_count, deleted_at
 						FROM movies
-						WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-						AND (genres @> $2 OR $2 = '{}')
+						WHERE deleted_at IS NOT NULL
 						ORDER BY %s %s, id ASC
-						LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+						LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
-
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -204,6 +468,9 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.DeletedAt,
 		)
 
 		if err != nil {
@@ -219,11 +486,813 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
+	return movies, metadata, nil
+}
+
+// Restore takes a movie back out of the trash, recording a revision
+// attributed to userID. The version is bumped so that clients holding the
+// pre-deletion version cannot overwrite the restored row.
+func (m MovieModel) Restore(id int64, userID int64) error {
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = recordMovieRevisions(ctx, tx, RevisionRestore, userID, `id = $1 AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			UPDATE movies
+			SET deleted_at = NULL, version = version + 1
+			WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// Purge permanently removes a movie that is in the trash, along with its
+// stored images.
+func (m MovieModel) Purge(id int64) error {
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	count, err := m.purge(`id = $1 AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// PurgeDeletedBefore permanently removes every movie that was moved to the
+// trash before cutoff, and returns how many were removed.
+func (m MovieModel) PurgeDeletedBefore(cutoff time.Time) (int64, error) {
+	return m.purge(`deleted_at < $1`, cutoff)
+}
+
+// purge deletes the movies matching condition and then the stored files of
+// their images. The image rows go with the movies by cascade; the CTE still
+// sees them, since every part of the statement reads the same snapshot. List
+// entries go by cascade too, so the lists they were on are renumbered to
+// close the gaps.
+func (m MovieModel) purge(condition string, args ...interface{}) (int64, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the affected lists as lockList does, so that their entries can't
+	// be moved while they are renumbered.
+	var listIDs []int64
+
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`
+			WITH locked AS (
+				SELECT id FROM lists
+				WHERE id IN (SELECT list_id FROM list_entries WHERE movie_id IN (SELECT id FROM movies WHERE %s))
+				FOR UPDATE
+			)
+			SELECT COALESCE(array_agg(id), '{}') FROM locked`, condition), args...).Scan(pq.Array(&listIDs))
+	if err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf(`
+			WITH purged AS (
+				DELETE FROM movies
+				WHERE %s
+				RETURNING id
+			)
+			SELECT purged.id, movie_images.key, movie_images.thumbnail_key
+			FROM purged
+			LEFT JOIN movie_images ON movie_images.movie_id = purged.id`, condition)
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	purged := map[int64]bool{}
+	keys := []string{}
+
+	for rows.Next() {
+		var (
+			id                int64
+			key, thumbnailKey sql.NullString
+		)
+
+		err := rows.Scan(&id, &key, &thumbnailKey)
+		if err != nil {
+			return 0, err
+		}
+
+		purged[id] = true
+
+		if key.Valid {
+			keys = append(keys, key.String, thumbnailKey.String)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	_, err = tx.ExecContext(ctx, `
+			UPDATE list_entries
+			SET position = renumbered.position
+			FROM (
+				SELECT list_id, movie_id, row_number() OVER (PARTITION BY list_id ORDER BY position) AS position
+				FROM list_entries
+				WHERE list_id = ANY($1)
+			) renumbered
+			WHERE list_entries.list_id = renumbered.list_id AND list_entries.movie_id = renumbered.movie_id
+			AND list_entries.position <> renumbered.position`, pq.Array(listIDs))
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	deleteFiles(m.Storage, keys...)
+
+	return int64(len(purged)), nil
+}
+
+// MovieQuery holds the criteria used to select movies for listings and
+// exports. Zero values mean the corresponding filter is not applied.
+type MovieQuery struct {
+	Title string
+	// SearchMode is either "plain", which matches whole words of Title, or
+	// "fuzzy", which also matches word prefixes and misspellings.
+	SearchMode string
+	Genres     []string
+	PersonID   int64
+	// GenresMode controls how Genres is matched: "all" requires every genre,
+	// "any" requires at least one and "none" excludes movies with any of them.
+	GenresMode    string
+	ExcludeGenres []string
+	YearMin       int32
+	YearMax       int32
+	RuntimeMin    int32
+	RuntimeMax    int32
+	// Fields limits the columns read to those behind the given fields. It
+	// narrows what is selected rather than which movies are.
+	Fields []string
+}
+
+// MovieFields lists the fields clients may ask for with ?fields=. Credits,
+// relevance, highlight, similarity, original_title and images aren't columns
+// of movies, so they don't affect which columns are read.
+var MovieFields = []string{
+	"id", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count",
+	"credits", "relevance", "highlight", "similarity", "original_title", "images",
+}
+
+func ValidateMovieFields(v *validator.Validator, fields []string) {
+	for _, field := range fields {
+		if !validator.In(field, MovieFields...) {
+			v.AddError("fields", fmt.Sprintf("unknown field %q, must be one of %s", field, strings.Join(MovieFields, ", ")))
+		}
+	}
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
+}
+
+// movieColumns returns the columns to read for the given fields, or every
+// column when fields is nil. The columns making up a movie's ETag are always
+// read, as is sortColumn, which cursors are built from.
+func movieColumns(fields []string, sortColumn string) []string {
+
+	all := []string{"id", "created_at", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count"}
+
+	if len(fields) == 0 {
+		return all
+	}
+
+	columns := []string{}
+
+	for _, column := range all {
+		switch {
+		case validator.In(column, "id", "version", "average_rating", "rating_count"),
+			column == sortColumn,
+			validator.In(column, fields...):
+			columns = append(columns, column)
+		}
+	}
+
+	return columns
+}
+
+// scanTargets returns the destinations for scanning the given columns into
+// movie, in the same order.
+func (movie *Movie) scanTargets(columns []string) []interface{} {
+
+	targets := make([]interface{}, len(columns))
+
+	for i, column := range columns {
+		switch column {
+		case "id":
+			targets[i] = &movie.ID
+		case "created_at":
+			targets[i] = &movie.CreatedAt
+		case "title":
+			targets[i] = &movie.Title
+		case "year":
+			targets[i] = &movie.Year
+		case "runtime":
+			targets[i] = &movie.Runtime
+		case "genres":
+			targets[i] = pq.Array(&movie.Genres)
+		case "version":
+			targets[i] = &movie.Version
+		case "average_rating":
+			targets[i] = &movie.AverageRating
+		case "rating_count":
+			targets[i] = &movie.RatingCount
+		}
+	}
+
+	return targets
+}
+
+// movieSearch holds the SQL expressions scoring and highlighting each movie
+// against the title search of a MovieQuery.
+type movieSearch struct {
+	rank      string
+	highlight string
+}
+
+// orderBy returns the ORDER BY expression for filters. Relevance is always
+// ordered best match first, so it has no descending form.
+func (s movieSearch) orderBy(filters Filters) string {
+	if filters.Sort == "relevance" {
+		return s.rank + " DESC"
+	}
+	return filters.sortColumn() + " " + filters.sortDirection()
+}
+
+// prefixQuery turns free text into a tsquery matching every word as a
+// prefix, e.g. "star wa" becomes "star:* & wa:*". Anything other than
+// letters and digits is dropped so the result is always valid tsquery syntax.
+func prefixQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// htmlEscape returns an SQL expression escaping the same characters in expr
+// as html.EscapeString. Titles are escaped before <mark> tags are added
+// around the matches, so that markup in a title is never passed through.
+func htmlEscape(expr string) string {
+	return fmt.Sprintf(`replace(replace(replace(replace(replace(%s, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`, expr)
+}
+
+// search returns the condition matching q.Title, along with the expressions
+// used to rank and highlight the matches. Alternate titles are searched as
+// well as the original, and a movie ranks by whichever matches best. In
+// fuzzy mode a title matches if every word is a prefix of a word in it, or if
+// it is similar enough to a run of words in it by trigram word similarity,
+// which catches typos. Each branch of the match is served by an index: the
+// full-text and trigram indexes on movies and on movie_titles.
+func (q MovieQuery) search(args []interface{}) (string, movieSearch, []interface{}) {
+
+	if q.Title == "" {
+		return "", movieSearch{rank: "0", highlight: "''"}, args
+	}
+
+	args = append(args, q.Title)
+	title := len(args)
+
+	var (
+		tsquery string
+		matches func(column string) string
+		ranks   func(column string) string
+	)
+
+	switch q.SearchMode {
+	case "fuzzy":
+		args = append(args, prefixQuery(q.Title))
+		tsquery = fmt.Sprintf("to_tsquery('simple', $%d)", len(args))
+		matches = func(column string) string {
+			return fmt.Sprintf("(to_tsvector('simple', %[1]s) @@ %[2]s OR $%[3]d <%% %[1]s)", column, tsquery, title)
+		}
+		ranks = func(column string) string {
+			return fmt.Sprintf("greatest(ts_rank(to_tsvector('simple', %[1]s), %[2]s), word_similarity($%[3]d, %[1]s))", column, tsquery, title)
+		}
+	default:
+		tsquery = fmt.Sprintf("plainto_tsquery('simple', $%d)", title)
+		matches = func(column string) string {
+			return fmt.Sprintf("to_tsvector('simple', %s) @@ %s", column, tsquery)
+		}
+		ranks = func(column string) string {
+			return fmt.Sprintf("ts_rank(to_tsvector('simple', %s), %s)", column, tsquery)
+		}
+	}
+
+	condition := fmt.Sprintf(`movies.id IN (
+							SELECT id FROM movies WHERE %s
+							UNION
+							SELECT movie_id FROM movie_titles WHERE %s)`, matches("title"), matches("title"))
+
+	rank := fmt.Sprintf(`greatest(%s,
+							COALESCE((SELECT max(%s) FROM movie_titles WHERE movie_titles.movie_id = movies.id), 0))`,
+		ranks("movies.title"), ranks("movie_titles.title"))
+
+	// The highlight is of the best matching title, preferring the original
+	// on a tie.
+	highlight := fmt.Sprintf(`(
+							SELECT ts_headline('simple', %s, %s, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')
+							FROM (
+								SELECT movies.title, %s AS rank, true AS original
+								UNION ALL
+								SELECT movie_titles.title, %s, false FROM movie_titles WHERE movie_titles.movie_id = movies.id
+							) matched
+							ORDER BY matched.rank DESC, matched.original DESC
+							LIMIT 1)`, htmlEscape("matched.title"), tsquery, ranks("movies.title"), ranks("movie_titles.title"))
+
+	return condition, movieSearch{rank: rank, highlight: highlight}, args
+}
+
+// where returns the SQL conditions for q, appending their parameters to
+// args, and the expressions for ranking any title search. Only the filters
+// actually in use are emitted, rather than guarding each one with an
+// "OR $n = ”" escape hatch, so the planner can use the matching index for
+// whichever filters are present.
+func (q MovieQuery) where(args []interface{}) (string, movieSearch, []interface{}) {
+
+	conditions := []string{"deleted_at IS NULL"}
+
+	condition, search, args := q.search(args)
+	if condition != "" {
+		conditions = append(conditions, condition)
+	}
+
+	// The GIN index on genres serves both @> and &&. Exclusions can't use
+	// it, but are applied alongside whichever conditions can.
+	if len(q.Genres) > 0 {
+		args = append(args, pq.Array(q.Genres))
+		switch q.GenresMode {
+		case "any":
+			conditions = append(conditions, fmt.Sprintf("genres && $%d", len(args)))
+		case "none":
+			conditions = append(conditions, fmt.Sprintf("NOT (genres && $%d)", len(args)))
+		default:
+			conditions = append(conditions, fmt.Sprintf("genres @> $%d", len(args)))
+		}
+	}
+
+	if len(q.ExcludeGenres) > 0 {
+		args = append(args, pq.Array(q.ExcludeGenres))
+		conditions = append(conditions, fmt.Sprintf("NOT (genres && $%d)", len(args)))
+	}
+
+	ranges := []struct {
+		column string
+		op     string
+		value  int32
+	}{
+		{"year", ">=", q.YearMin},
+		{"year", "<=", q.YearMax},
+		{"runtime", ">=", q.RuntimeMin},
+		{"runtime", "<=", q.RuntimeMax},
+	}
+
+	for _, r := range ranges {
+		if r.value != 0 {
+			args = append(args, r.value)
+			conditions = append(conditions, fmt.Sprintf("%s %s $%d", r.column, r.op, len(args)))
+		}
+	}
+
+	if q.PersonID != 0 {
+		args = append(args, q.PersonID)
+		conditions = append(conditions, fmt.Sprintf("id IN (SELECT movie_id FROM movie_credits WHERE person_id = $%d)", len(args)))
+	}
+
+	return strings.Join(conditions, " AND "), search, args
+}
+
+func (m MovieModel) GetAll(q MovieQuery, filters Filters) ([]*Movie, Metadata, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getAllMovies(ctx, m.DB, q, filters)
+}
+
+// GetAllWithFacets is GetAll plus the requested facet counts. The listing
+// and the counts are read in one repeatable read transaction, so they agree
+// with each other even while movies are being written.
+func (m MovieModel) GetAllWithFacets(q MovieQuery, filters Filters, facets []string) ([]*Movie, Metadata, *Facets, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+	defer tx.Rollback()
+
+	movies, metadata, err := getAllMovies(ctx, tx, q, filters)
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+
+	counts, err := getMovieFacets(ctx, tx, q, facets)
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, Metadata{}, nil, err
+	}
+
+	return movies, metadata, counts, nil
+}
+
+func getAllMovies(ctx context.Context, db queryer, q MovieQuery, filters Filters) ([]*Movie, Metadata, error) {
+
+	if filters.CursorMode {
+		return getMoviesByCursor(ctx, db, q, filters)
+	}
+
+	where, search, args := q.where(nil)
+	args = append(args, filters.limit(), filters.offset())
+
+	columns := movieColumns(q.Fields, filters.sortColumn())
+
+	query := fmt.Sprintf(`
+						SELECT count(*) OVER(), %s, %s, %s
+						FROM movies
+						WHERE %s
+						ORDER BY %s, id ASC
+						LIMIT $%d OFFSET $%d`, strings.Join(columns, ", "), search.rank, search.highlight, where, search.orderBy(filters), len(args)-1, len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+
+		var movie Movie
+
+		targets := append([]interface{}{&totalRecords}, movie.scanTargets(columns)...)
+
+		err := rows.Scan(append(targets, &movie.Relevance, &movie.Highlight)...)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
 	// If everything went OK, then return the slice of movies.
 	return movies, metadata, nil
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// getMoviesByCursor pages through movies using keyset pagination. Rather than
+// skipping rows with OFFSET, it seeks directly past the (sort column, id) pair
+// recorded in the cursor, so every page costs the same regardless of depth.
+func getMoviesByCursor(ctx context.Context, db queryer, q MovieQuery, filters Filters) ([]*Movie, Metadata, error) {
+
+	c, err := filters.cursor()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	column := filters.sortColumn()
+	direction := filters.sortDirection()
+	idDirection := "ASC"
+
+	// Walking backwards means flipping every comparison and ordering, then
+	// reversing the fetched rows so the page is still returned in sort order.
+	if c.Before {
+		direction = reverseDirection(direction)
+		idDirection = reverseDirection(idDirection)
+	}
+
+	where, search, args := q.where(nil)
+
+	if filters.Cursor != "" {
+		value, err := movieCursorValue(column, c.Value)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		args = append(args, value, c.ID)
+		where += fmt.Sprintf(" AND (%[1]s %[2]s $%[4]d OR (%[1]s = $%[4]d AND id %[3]s $%[5]d))",
+			column, comparisonOperator(direction), comparisonOperator(idDirection), len(args)-1, len(args))
+	}
+
+	// Fetch one row more than requested to find out whether another page
+	// exists in the direction of travel.
+	args = append(args, filters.limit()+1)
+
+	columns := movieColumns(q.Fields, column)
+
+	query := fmt.Sprintf(`
+						SELECT %s, %s, %s
+						FROM movies
+						WHERE %s
+						ORDER BY %s %s, id %s
+						LIMIT $%d`, strings.Join(columns, ", "), search.rank, search.highlight, where, column, direction, idDirection, len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+
+		var movie Movie
+
+		err := rows.Scan(append(movie.scanTargets(columns), &movie.Relevance, &movie.Highlight)...)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	hasMore := len(movies) > filters.limit()
+	if hasMore {
+		movies = movies[:filters.limit()]
+	}
+
+	if c.Before {
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+	}
+
+	if len(movies) == 0 {
+		return movies, calculateCursorMetadata(filters.PageSize, nil, nil), nil
+	}
+
+	var next, prev *cursor
+
+	first, last := movies[0], movies[len(movies)-1]
+
+	// Going forwards there is always a previous page unless this is the
+	// first one; going backwards there is always a next page.
+	if hasMore || c.Before {
+		next = &cursor{Sort: filters.Sort, Value: movieSortValue(last, column), ID: last.ID}
+	}
+	if (hasMore && c.Before) || (!c.Before && filters.Cursor != "") {
+		prev = &cursor{Sort: filters.Sort, Value: movieSortValue(first, column), ID: first.ID, Before: true}
+	}
+
+	return movies, calculateCursorMetadata(filters.PageSize, next, prev), nil
+}
+
+// Export calls fn for every movie matching q, in
+// the order given by filters.Sort. Rows are read through a server-side cursor
+// in fixed-size batches, so memory use does not grow with the result set. The
+// export as a whole runs until ctx is cancelled rather than under the usual 3
+// second timeout, because its duration depends on the size of the catalog,
+// but each batch is fetched under that timeout. Batches are read in full
+// before fn is called, so a slow fn doesn't count against it.
+func (m MovieModel) Export(ctx context.Context, q MovieQuery, filters Filters, fn func(*Movie) error) error {
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	where, search, args := q.where(nil)
+
+	query := fmt.Sprintf(`
+						DECLARE movies_export NO SCROLL CURSOR FOR
+						SELECT id, created_at, title, year, runtime, genres, version, average_rating, rating_count
+						FROM movies
+						WHERE %s
+						ORDER BY %s, id ASC`, where, search.orderBy(filters))
+
+	declareCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err = tx.ExecContext(declareCtx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		movies, err := fetchExportBatch(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		if len(movies) == 0 {
+			return tx.Commit()
+		}
+
+		for _, movie := range movies {
+			err = fn(movie)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fetchExportBatch reads the next batch of rows from the cursor declared by
+// Export.
+func fetchExportBatch(ctx context.Context, tx *sql.Tx) ([]*Movie, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, `FETCH FORWARD 500 FROM movies_export`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := make([]*Movie, 0, 500)
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// movieSortValue returns the value of the given sort column for a movie, in
+// the text form PostgreSQL accepts for that column's type.
+func movieSortValue(movie *Movie, column string) string {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.Itoa(int(movie.Year))
+	case "runtime":
+		return strconv.Itoa(int(movie.Runtime))
+	case "average_rating":
+		return strconv.FormatFloat(movie.AverageRating, 'f', 2, 64)
+	default:
+		return strconv.FormatInt(movie.ID, 10)
+	}
+}
+
+// movieCursorValue parses a cursor's sort value for column, so that a
+// tampered cursor is rejected with ErrInvalidCursor rather than failing in
+// the database.
+func movieCursorValue(column, value string) (interface{}, error) {
+	switch column {
+	case "title":
+		if !utf8.ValidString(value) || strings.ContainsRune(value, 0) {
+			return nil, ErrInvalidCursor
+		}
+		return value, nil
+	case "year", "runtime":
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return n, nil
+	case "average_rating":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, ErrInvalidCursor
+		}
+		return f, nil
+	default:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return n, nil
+	}
+}
+
+// ValidateMovieQuery checks q, along with the parts of filters that depend
+// on it.
+func ValidateMovieQuery(v *validator.Validator, q MovieQuery, filters Filters) {
+	v.Check(q.PersonID >= 0, "person_id", "must be a positive integer")
+
+	v.Check(validator.In(q.SearchMode, "plain", "fuzzy"), "search_mode", "must be plain or fuzzy")
+	v.Check(q.SearchMode == "plain" || q.Title != "", "search_mode", "requires the title parameter")
+	v.Check(filters.Sort != "relevance" || q.Title != "", "sort", "relevance requires the title parameter")
+	v.Check(filters.Sort != "relevance" || !filters.CursorMode, "sort", "relevance must not be used together with cursor")
+
+	if filters.Cursor != "" && filters.Sort != "relevance" && validator.In(filters.Sort, filters.SortSafelist...) {
+		if c, err := filters.cursor(); err == nil && c.Sort == filters.Sort {
+			_, err = movieCursorValue(filters.sortColumn(), c.Value)
+			v.Check(err == nil, "cursor", "must be a valid cursor")
+		}
+	}
+
+	v.Check(validator.In(q.GenresMode, "all", "any", "none"), "genres_mode", "must be one of all, any or none")
+	v.Check(q.GenresMode == "all" || len(q.Genres) > 0, "genres_mode", "requires the genres parameter")
+
+	v.Check(q.YearMin >= 0, "year_min", "must not be negative")
+	v.Check(q.YearMax >= 0, "year_max", "must not be negative")
+	v.Check(q.YearMin == 0 || q.YearMax == 0 || q.YearMin <= q.YearMax, "year_min", "must not be greater than year_max")
+
+	v.Check(q.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	v.Check(q.RuntimeMax >= 0, "runtime_max", "must not be negative")
+	v.Check(q.RuntimeMin == 0 || q.RuntimeMax == 0 || q.RuntimeMin <= q.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+}
+
+// ValidateMovieGenreFilters checks that no genre is filtered on twice. It must
+// run after aliases are resolved, since two spellings of a genre only repeat
+// once they are both its slug.
+func ValidateMovieGenreFilters(v *validator.Validator, q MovieQuery) {
+	v.Check(validator.Unique(append(append([]string{}, q.Genres...), q.ExcludeGenres...)), "exclude_genres", "must not repeat or overlap with genres")
+}
+
+// ValidateMovie checks movie, including that each of its genres is in vocab.
+// Aliases should already have been resolved with vocab.Resolve.
+func ValidateMovie(v *validator.Validator, movie *Movie, vocab GenreVocabulary) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 	v.Check(movie.Year != 0, "year", "must be provided")
@@ -235,4 +1304,10 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+
+	for _, genre := range movie.Genres {
+		if !vocab.Known(genre) {
+			v.AddError("genres", fmt.Sprintf("unknown genre %q", genre))
+		}
+	}
 }
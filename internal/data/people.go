@@ -0,0 +1,409 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+var CreditRoles = []string{"director", "writer", "actor"}
+
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	BirthYear int32     `json:"birth_year,omitempty"`
+	Biography string    `json:"biography,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// Credit links a person to a movie in a given role. Character is only used
+// for actors, and BillingOrder ranks credits within the same role.
+type Credit struct {
+	MovieID      int64  `json:"-"`
+	PersonID     int64  `json:"person_id"`
+	Name         string `json:"name"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int32  `json:"billing_order"`
+}
+
+type PeopleModel struct {
+	DB *sql.DB
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(person.BirthYear == 0 || person.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+	v.Check(person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	v.Check(len(person.Biography) <= 10_000, "biography", "must not be more than 10000 bytes long")
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+	v.Check(validator.In(credit.Role, CreditRoles...), "role", "must be one of director, writer or actor")
+	v.Check(credit.Character == "" || credit.Role == "actor", "character", "may only be set for actors")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.BillingOrder >= 0, "billing_order", "must not be negative")
+}
+
+func (m PeopleModel) Insert(person *Person) error {
+	query := `
+		INSERT INTO people (name, birth_year, biography)
+		VALUES ($1, NULLIF($2, 0), $3)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{person.Name, person.BirthYear, person.Biography}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PeopleModel) Get(id int64) (*Person, error) {
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+			SELECT id, created_at, name, COALESCE(birth_year, 0), biography, version
+			FROM people
+			WHERE id = $1`
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.BirthYear,
+		&person.Biography,
+		&person.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// Update saves the changes to person, provided nobody else has updated it
+// since it was read. Movies crediting the person embed their name, so their
+// versions are bumped too, which keeps the movies' ETags honest, and their
+// revisions are attributed to userID.
+func (m PeopleModel) Update(person *Person, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+			UPDATE people
+			SET name = $1, birth_year = NULLIF($2, 0), biography = $3, version = version + 1
+			WHERE id = $4 AND version = $5
+			RETURNING version`
+
+	args := []interface{}{
+		person.Name,
+		person.BirthYear,
+		person.Biography,
+		person.ID,
+		person.Version,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = touchCreditedMovies(ctx, tx, person.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m PeopleModel) Delete(id int64, userID int64) error {
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Bump the movies first, while the credits that are about to be removed
+	// by the cascade still identify them.
+	err = touchCreditedMovies(ctx, tx, id, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			DELETE FROM people
+			WHERE id = $1`
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// touchCreditedMovies records a revision of every movie crediting the person
+// and bumps their versions.
+func touchCreditedMovies(ctx context.Context, tx *sql.Tx, personID, userID int64) error {
+	movieIDs, err := recordMovieRevisions(ctx, tx, RevisionCredits, userID, `id IN (SELECT movie_id FROM movie_credits WHERE person_id = $1)`, personID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			UPDATE movies
+			SET version = version + 1
+			WHERE id = ANY($1)`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(movieIDs))
+	return err
+}
+
+func (m PeopleModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+
+	query := fmt.Sprintf(`
+						SELECT count(*) OVER(), id, created_at, name, COALESCE(birth_year, 0), biography, version
+						FROM people
+						WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+						ORDER BY %s %s, id ASC
+						LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+
+		var person Person
+
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.BirthYear,
+			&person.Biography,
+			&person.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+// AddCredit attaches a person to a movie, or updates the character and
+// billing order if they are already credited in that role. The movie's
+// version is bumped in the same transaction, since its credits are part of
+// its representation, and a revision attributed to userID is recorded.
+func (m PeopleModel) AddCredit(credit *Credit, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+			INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (movie_id, person_id, role)
+			DO UPDATE SET character = EXCLUDED.character, billing_order = EXCLUDED.billing_order`
+
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "violates foreign key constraint"):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = recordMovieRevisions(ctx, tx, RevisionCredits, userID, `id = $1`, credit.MovieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET version = version + 1 WHERE id = $1`, credit.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveCredit detaches a person from a movie. An empty role removes every
+// credit the person has on the movie.
+func (m PeopleModel) RemoveCredit(movieID, personID int64, role string, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+			DELETE FROM movie_credits
+			WHERE movie_id = $1 AND person_id = $2 AND (role = $3 OR $3 = '')`
+
+	result, err := tx.ExecContext(ctx, query, movieID, personID, role)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = recordMovieRevisions(ctx, tx, RevisionCredits, userID, `id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET version = version + 1 WHERE id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetCreditsForMovies returns the credits of each of the given movies, keyed
+// by movie ID, using a single query. Credits are ordered by role and then by
+// billing order.
+func (m PeopleModel) GetCreditsForMovies(movieIDs []int64) (map[int64][]*Credit, error) {
+
+	credits := make(map[int64][]*Credit, len(movieIDs))
+
+	if len(movieIDs) == 0 {
+		return credits, nil
+	}
+
+	query := `
+			SELECT movie_credits.movie_id, people.id, people.name, movie_credits.role,
+				movie_credits.character, movie_credits.billing_order
+			FROM movie_credits
+			INNER JOIN people ON people.id = movie_credits.person_id
+			WHERE movie_credits.movie_id = ANY($1)
+			ORDER BY array_position($2::text[], movie_credits.role), movie_credits.billing_order, people.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs), pq.Array(CreditRoles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Name,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits[credit.MovieID] = append(credits[credit.MovieID], &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
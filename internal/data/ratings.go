@@ -0,0 +1,295 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+)
+
+var (
+	ErrDuplicateRating = errors.New("duplicate rating")
+)
+
+// Rating is a user's score for a movie, with an optional written review.
+// Each user has at most one rating per movie.
+type Rating struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name,omitempty"`
+	Score     int32     `json:"score"`
+	Review    string    `json:"review,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+type RatingModel struct {
+	DB *sql.DB
+}
+
+func ValidateRating(v *validator.Validator, rating *Rating) {
+	v.Check(rating.Score != 0, "score", "must be provided")
+	v.Check(rating.Score >= 1 && rating.Score <= 10, "score", "must be between 1 and 10")
+	v.Check(len(rating.Review) <= 20_000, "review", "must not be more than 20000 bytes long")
+}
+
+// lockMovie takes a row lock on the rated movie before its ratings change.
+// Concurrent rating writes for the same movie are serialised this way, so
+// each refreshMovieRating sees every committed rating rather than a snapshot
+// taken before a competing write finished.
+func lockMovie(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	var id int64
+
+	err := tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, movieID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// refreshMovieRating recalculates the rating aggregates stored on a movie.
+// It runs in the same transaction as the rating change, so listings can read
+// the aggregates straight off the movies table.
+func refreshMovieRating(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	query := `
+			UPDATE movies
+			SET (average_rating, rating_count) = (
+				SELECT COALESCE(round(avg(score), 2), 0), count(*)
+				FROM ratings
+				WHERE movie_id = $1
+			)
+			WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID)
+	return err
+}
+
+func (m RatingModel) Insert(rating *Rating) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, rating.MovieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO ratings (movie_id, user_id, score, review)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at, updated_at, version`
+
+	args := []interface{}{rating.MovieID, rating.UserID, rating.Score, rating.Review}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&rating.ID, &rating.CreatedAt, &rating.UpdatedAt, &rating.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "ratings_movie_id_user_id_key"`:
+			return ErrDuplicateRating
+		default:
+			return err
+		}
+	}
+
+	err = refreshMovieRating(ctx, tx, rating.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m RatingModel) GetForUser(movieID, userID int64) (*Rating, error) {
+
+	query := `
+			SELECT id, created_at, updated_at, movie_id, user_id, score, review, version
+			FROM ratings
+			WHERE movie_id = $1 AND user_id = $2`
+
+	var rating Rating
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, userID).Scan(
+		&rating.ID,
+		&rating.CreatedAt,
+		&rating.UpdatedAt,
+		&rating.MovieID,
+		&rating.UserID,
+		&rating.Score,
+		&rating.Review,
+		&rating.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rating, nil
+}
+
+func (m RatingModel) Update(rating *Rating) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, rating.MovieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			UPDATE ratings
+			SET score = $1, review = $2, updated_at = NOW(), version = version + 1
+			WHERE id = $3 AND version = $4
+			RETURNING updated_at, version`
+
+	args := []interface{}{rating.Score, rating.Review, rating.ID, rating.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&rating.UpdatedAt, &rating.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = refreshMovieRating(ctx, tx, rating.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m RatingModel) Delete(movieID, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			DELETE FROM ratings
+			WHERE movie_id = $1 AND user_id = $2`
+
+	result, err := tx.ExecContext(ctx, query, movieID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = refreshMovieRating(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAllForMovie returns the ratings for a movie along with the name of the
+// user who wrote each one.
+func (m RatingModel) GetAllForMovie(movieID int64, filters Filters) ([]*Rating, Metadata, error) {
+
+	query := fmt.Sprintf(`
+						SELECT count(*) OVER(), ratings.id, ratings.created_at, ratings.updated_at, ratings.movie_id,
+							ratings.user_id, users.name, ratings.score, ratings.review, ratings.version
+						FROM ratings
+						INNER JOIN users ON users.id = ratings.user_id
+						WHERE ratings.movie_id = $1
+						ORDER BY ratings.%s %s, ratings.id ASC
+						LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	ratings := []*Rating{}
+
+	for rows.Next() {
+
+		var rating Rating
+
+		err := rows.Scan(
+			&totalRecords,
+			&rating.ID,
+			&rating.CreatedAt,
+			&rating.UpdatedAt,
+			&rating.MovieID,
+			&rating.UserID,
+			&rating.UserName,
+			&rating.Score,
+			&rating.Review,
+			&rating.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		ratings = append(ratings, &rating)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return ratings, metadata, nil
+}
@@ -0,0 +1,231 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Revision reasons say which part of a movie an update changed. Every bump of
+// a movie's version records a revision, but only details updates and genre
+// renames change the recorded fields, so revisions for the other reasons have
+// no changes.
+const (
+	RevisionDetails = "details"
+	RevisionCredits = "credits"
+	RevisionImages  = "images"
+	RevisionTitles  = "titles"
+	RevisionGenres  = "genres"
+	RevisionRestore = "restore"
+)
+
+// MovieRevision records the state of a movie immediately before one of its
+// updates. Changes lists the fields that update modified, comparing the
+// recorded values with those of the following revision, or with the current
+// movie for the most recent revision.
+type MovieRevision struct {
+	ID        int64                  `json:"id"`
+	MovieID   int64                  `json:"movie_id"`
+	Version   int32                  `json:"version"`
+	Reason    string                 `json:"reason"`
+	ChangedBy *int64                 `json:"changed_by"`
+	ChangedAt time.Time              `json:"changed_at"`
+	Changes   map[string]FieldChange `json:"changes"`
+	Movie     Movie                  `json:"-"`
+}
+
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+// recordMovieRevisions locks the movies matching condition and records their
+// current state as revisions attributed to userID. It returns the IDs of
+// those movies, which are the ones whose versions must then be bumped in the
+// same transaction.
+func recordMovieRevisions(ctx context.Context, tx *sql.Tx, reason string, userID int64, condition string, args ...interface{}) ([]int64, error) {
+	query := fmt.Sprintf(`
+			INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, changed_by, reason)
+			SELECT id, version, title, year, runtime, genres, $%d, $%d
+			FROM (
+				SELECT id, version, title, year, runtime, genres
+				FROM movies
+				WHERE %s
+				FOR UPDATE
+			) AS previous
+			RETURNING movie_id`, len(args)+1, len(args)+2, condition)
+
+	rows, err := tx.QueryContext(ctx, query, append(args, userID, reason)...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var movieIDs []int64
+
+	for rows.Next() {
+		var movieID int64
+
+		err := rows.Scan(&movieID)
+		if err != nil {
+			return nil, err
+		}
+
+		movieIDs = append(movieIDs, movieID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movieIDs, nil
+}
+
+func diffMovies(from, to *Movie) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+
+	if from.Title != to.Title {
+		changes["title"] = FieldChange{From: from.Title, To: to.Title}
+	}
+	if from.Year != to.Year {
+		changes["year"] = FieldChange{From: from.Year, To: to.Year}
+	}
+	if from.Runtime != to.Runtime {
+		changes["runtime"] = FieldChange{From: from.Runtime, To: to.Runtime}
+	}
+
+	genresChanged := len(from.Genres) != len(to.Genres)
+	for i := 0; !genresChanged && i < len(from.Genres); i++ {
+		genresChanged = from.Genres[i] != to.Genres[i]
+	}
+	if genresChanged {
+		changes["genres"] = FieldChange{From: from.Genres, To: to.Genres}
+	}
+
+	return changes
+}
+
+func (m MovieRevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+
+	// The values each revision was changed to are those of the next revision,
+	// or of the movie itself for the latest one. LEAD() is evaluated before
+	// LIMIT, so this holds across page boundaries too.
+	query := fmt.Sprintf(`
+						SELECT count(*) OVER(), r.id, r.movie_id, r.version, r.reason, r.title, r.year, r.runtime, r.genres,
+							r.changed_by, r.changed_at,
+							COALESCE(LEAD(r.title) OVER w, m.title),
+							COALESCE(LEAD(r.year) OVER w, m.year),
+							COALESCE(LEAD(r.runtime) OVER w, m.runtime),
+							COALESCE(LEAD(r.genres) OVER w, m.genres)
+						FROM movie_revisions r
+						INNER JOIN movies m ON m.id = r.movie_id
+						WHERE r.movie_id = $1 AND m.deleted_at IS NULL
+						WINDOW w AS (ORDER BY r.version)
+						ORDER BY r.%s %s
+						LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+
+		var revision MovieRevision
+		var next Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.ID,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Reason,
+			&revision.Movie.Title,
+			&revision.Movie.Year,
+			&revision.Movie.Runtime,
+			pq.Array(&revision.Movie.Genres),
+			&revision.ChangedBy,
+			&revision.ChangedAt,
+			&next.Title,
+			&next.Year,
+			&next.Runtime,
+			pq.Array(&next.Genres),
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revision.Changes = diffMovies(&revision.Movie, &next)
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// Get returns a single revision of a movie. Changes is left empty, as only
+// the recorded field values are needed to revert to it.
+func (m MovieRevisionModel) Get(movieID, id int64) (*MovieRevision, error) {
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+			SELECT id, movie_id, version, reason, title, year, runtime, genres, changed_by, changed_at
+			FROM movie_revisions
+			WHERE movie_id = $1 AND id = $2`
+
+	var revision MovieRevision
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, id).Scan(
+		&revision.ID,
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Reason,
+		&revision.Movie.Title,
+		&revision.Movie.Year,
+		&revision.Movie.Runtime,
+		pq.Array(&revision.Movie.Genres),
+		&revision.ChangedBy,
+		&revision.ChangedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
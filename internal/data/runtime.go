@@ -3,41 +3,150 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
 type Runtime int32
 
-var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
+// RuntimeFormat picks how a runtime is written in responses.
+type RuntimeFormat string
+
+const (
+	RuntimeFormatMins    RuntimeFormat = "mins"    // "102 mins"
+	RuntimeFormatMinutes RuntimeFormat = "minutes" // 102
+	RuntimeFormatHours   RuntimeFormat = "hours"   // "1h 42m"
+	RuntimeFormatISO8601 RuntimeFormat = "iso8601" // "PT1H42M"
+)
+
+var RuntimeFormats = []string{
+	string(RuntimeFormatMins),
+	string(RuntimeFormatMinutes),
+	string(RuntimeFormatHours),
+	string(RuntimeFormatISO8601),
+}
+
+var ErrInvalidRuntimeFormat = errors.New(`invalid runtime format, must be a number of minutes such as 102, "102 mins" or "1 min", hours and minutes such as "1h 42m", or ISO 8601 such as "PT102M" or "PT1H42M"`)
+
+var (
+	runtimeMinsRX  = regexp.MustCompile(`^(\d+)\s*(?i:mins?|minutes?)$`)
+	runtimeHoursRX = regexp.MustCompile(`^(?i:(?:(\d+)\s*h)?\s*(?:(\d+)\s*m)?)$`)
+	runtimeISORX   = regexp.MustCompile(`^(?i:PT(?:(\d+)H)?(?:(\d+)M)?)$`)
+)
 
 func (r Runtime) MarshalJSON() ([]byte, error) {
-	jsonvalue := fmt.Sprintf("%d mins", r)
+	return r.MarshalFormat(RuntimeFormatMins)
+}
+
+// MarshalFormat encodes r as JSON in the given format. An unknown format
+// falls back to "<n> mins".
+func (r Runtime) MarshalFormat(format RuntimeFormat) ([]byte, error) {
+
+	hours, minutes := r/60, r%60
+
+	var jsonvalue string
+
+	switch format {
+	case RuntimeFormatMinutes:
+		return []byte(strconv.Itoa(int(r))), nil
+	case RuntimeFormatHours:
+		switch {
+		case hours == 0:
+			jsonvalue = fmt.Sprintf("%dm", minutes)
+		case minutes == 0:
+			jsonvalue = fmt.Sprintf("%dh", hours)
+		default:
+			jsonvalue = fmt.Sprintf("%dh %dm", hours, minutes)
+		}
+	case RuntimeFormatISO8601:
+		switch {
+		case hours == 0:
+			jsonvalue = fmt.Sprintf("PT%dM", minutes)
+		case minutes == 0:
+			jsonvalue = fmt.Sprintf("PT%dH", hours)
+		default:
+			jsonvalue = fmt.Sprintf("PT%dH%dM", hours, minutes)
+		}
+	default:
+		jsonvalue = fmt.Sprintf("%d mins", r)
+	}
+
 	quotedJSONValue := strconv.Quote(jsonvalue)
 	return []byte(quotedJSONValue), nil
 }
 
+// UnmarshalJSON accepts a runtime either as a JSON number of minutes or as a
+// string in any of the forms ParseRuntime understands.
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 
-	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	s := string(jsonValue)
+
+	if strings.HasPrefix(s, `"`) {
+		unquotedJSONValue, err := strconv.Unquote(s)
+		if err != nil {
+			return ErrInvalidRuntimeFormat
+		}
+		s = unquotedJSONValue
+	}
+
+	runtime, err := ParseRuntime(s)
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return err
 	}
 
-	// Split the string to isolate the part containing the number.
-	parts := strings.Split(unquotedJSONValue, " ")
+	*r = runtime
+
+	return nil
+}
+
+// ParseRuntime reads a runtime written as a plain number of minutes ("102"),
+// as minutes with a unit ("102 mins", "1 min"), as hours and minutes
+// ("1h 42m", "2h") or as an ISO 8601 duration ("PT102M", "PT1H42M").
+func ParseRuntime(s string) (Runtime, error) {
+
+	s = strings.TrimSpace(s)
 
-	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+	if minutes, err := strconv.ParseInt(s, 10, 32); err == nil {
+		return Runtime(minutes), nil
 	}
 
-	i, err := strconv.ParseInt(parts[0], 10, 32)
-	if err != nil {
-		return ErrInvalidRuntimeFormat
+	var hours, minutes string
+
+	if m := runtimeMinsRX.FindStringSubmatch(s); m != nil {
+		minutes = m[1]
+	} else if m := runtimeISORX.FindStringSubmatch(s); m != nil {
+		hours, minutes = m[1], m[2]
+	} else if m := runtimeHoursRX.FindStringSubmatch(s); m != nil {
+		hours, minutes = m[1], m[2]
 	}
 
-	*r = Runtime(i)
+	if hours == "" && minutes == "" {
+		return 0, ErrInvalidRuntimeFormat
+	}
 
-	return nil
+	total := int64(0)
+
+	for _, part := range []struct {
+		value      string
+		multiplier int64
+	}{{hours, 60}, {minutes, 1}} {
+		if part.value == "" {
+			continue
+		}
+
+		n, err := strconv.ParseInt(part.value, 10, 32)
+		if err != nil {
+			return 0, ErrInvalidRuntimeFormat
+		}
+
+		total += n * part.multiplier
+	}
+
+	if total > math.MaxInt32 {
+		return 0, ErrInvalidRuntimeFormat
+	}
 
+	return Runtime(total), nil
 }
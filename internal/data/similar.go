@@ -0,0 +1,112 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// likedScore is the lowest rating counted as liking a movie when looking for
+// movies that the same users liked.
+const likedScore = 7
+
+// GetSimilar returns the movies most like the movie with the given ID, best
+// match first. Each is scored out of 1 from the overlap of their genres, how
+// close together they were released, how alike their titles are, and how
+// many users liked both. Only movies sharing a genre, a similar title or a
+// liker with the source are considered, and the source itself is left out.
+func (m MovieModel) GetSimilar(id int64, fields []string, filters Filters) ([]*Movie, Metadata, error) {
+
+	columns := movieColumns(fields, "id")
+
+	qualified := make([]string, len(columns))
+	for i, column := range columns {
+		qualified[i] = "movies." + column
+	}
+
+	// Co-raters are normalised by the geometric mean of the two rating
+	// counts, so that popular movies don't outrank everything else.
+	query := fmt.Sprintf(`
+			WITH source AS (
+				SELECT id, title, year, genres, rating_count
+				FROM movies
+				WHERE id = $1 AND deleted_at IS NULL
+			),
+			co_ratings AS (
+				SELECT theirs.movie_id, count(*) AS co_raters
+				FROM ratings mine
+				INNER JOIN ratings theirs ON theirs.user_id = mine.user_id AND theirs.movie_id <> mine.movie_id
+				WHERE mine.movie_id = $1 AND mine.score >= $2 AND theirs.score >= $2
+				GROUP BY theirs.movie_id
+			)
+			SELECT count(*) OVER(), %s,
+				0.45 * COALESCE(
+					cardinality(ARRAY(SELECT unnest(movies.genres) INTERSECT SELECT unnest(source.genres)))::float8 /
+					NULLIF(cardinality(ARRAY(SELECT unnest(movies.genres) UNION SELECT unnest(source.genres))), 0), 0)
+				+ 0.2 / (1 + abs(movies.year - source.year) / 5.0)
+				+ 0.15 * similarity(movies.title, source.title)
+				+ 0.2 * COALESCE(co_ratings.co_raters / sqrt(movies.rating_count * source.rating_count::float8), 0)
+				AS similarity
+			FROM movies
+			CROSS JOIN source
+			LEFT JOIN co_ratings ON co_ratings.movie_id = movies.id
+			WHERE movies.id <> source.id AND movies.deleted_at IS NULL
+			AND (movies.genres && source.genres OR movies.title %% source.title OR co_ratings.co_raters IS NOT NULL)
+			ORDER BY similarity DESC, movies.id ASC
+			LIMIT $3 OFFSET $4`, strings.Join(qualified, ", "))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, likedScore, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		targets := append([]interface{}{&totalRecords}, movie.scanTargets(columns)...)
+
+		err := rows.Scan(append(targets, &movie.Similarity)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// SimilarityState returns a key that changes whenever anything GetSimilar
+// scores on might have. It is the counter in movie_changes, which triggers
+// bump on every write to movies or ratings, so reading it costs a single row
+// lookup.
+func (m MovieModel) SimilarityState() (string, error) {
+	query := `SELECT version FROM movie_changes`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var version int64
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&version)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(version, 10), nil
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// LanguageTagRX matches the BCP 47 tags titles can be keyed by: a language,
+// optionally followed by a script and a region, such as "de", "pt-BR" or
+// "zh-Hant-TW".
+var LanguageTagRX = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z]{4})?(-[A-Za-z]{2}|-[0-9]{3})?$`)
+
+// AlternateTitle is the title a movie is known by in a given language.
+type AlternateTitle struct {
+	MovieID  int64  `json:"-"`
+	Language string `json:"language"`
+	Title    string `json:"title"`
+}
+
+type TitleModel struct {
+	DB *sql.DB
+}
+
+// CanonicalLanguageTag returns tag in the conventional case for each subtag,
+// e.g. "pt-br" becomes "pt-BR" and "ZH-HANT" becomes "zh-Hant". The second
+// return value is false if tag isn't one LanguageTagRX accepts.
+func CanonicalLanguageTag(tag string) (string, bool) {
+
+	if !LanguageTagRX.MatchString(tag) {
+		return "", false
+	}
+
+	subtags := strings.Split(tag, "-")
+
+	for i, subtag := range subtags {
+		switch {
+		case i == 0:
+			subtags[i] = strings.ToLower(subtag)
+		case len(subtag) == 4:
+			subtags[i] = strings.ToUpper(subtag[:1]) + strings.ToLower(subtag[1:])
+		default:
+			subtags[i] = strings.ToUpper(subtag)
+		}
+	}
+
+	return strings.Join(subtags, "-"), true
+}
+
+// LanguageFallbacks expands languages, in order of preference, into the
+// list of tags to look titles up by. Each tag is followed by its less
+// specific forms, as in the RFC 4647 lookup scheme, so "de-AT, en" becomes
+// "de-AT, de, en".
+func LanguageFallbacks(languages []string) []string {
+
+	fallbacks := []string{}
+
+	for _, language := range languages {
+		subtags := strings.Split(language, "-")
+
+		for n := len(subtags); n > 0; n-- {
+			tag := strings.Join(subtags[:n], "-")
+			if !validator.In(tag, fallbacks...) {
+				fallbacks = append(fallbacks, tag)
+			}
+		}
+	}
+
+	return fallbacks
+}
+
+func ValidateAlternateTitle(v *validator.Validator, title *AlternateTitle) {
+	v.Check(validator.Matches(title.Language, LanguageTagRX), "language", "must be a BCP 47 language tag such as de or pt-BR")
+	v.Check(title.Title != "", "title", "must be provided")
+	v.Check(len(title.Title) <= 500, "title", "must not be more than 500 bytes long")
+}
+
+// Set adds or replaces the title of a movie in a language. The movie's
+// version is bumped in the same transaction, since its titles are part of
+// its representation, and a revision attributed to userID is recorded.
+func (m TitleModel) Set(title *AlternateTitle, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, title.MovieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO movie_titles (movie_id, language, title)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (movie_id, language)
+			DO UPDATE SET title = EXCLUDED.title`
+
+	_, err = tx.ExecContext(ctx, query, title.MovieID, title.Language, title.Title)
+	if err != nil {
+		return err
+	}
+
+	_, err = recordMovieRevisions(ctx, tx, RevisionTitles, userID, `id = $1`, title.MovieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET version = version + 1 WHERE id = $1`, title.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m TitleModel) Remove(movieID int64, language string, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM movie_titles WHERE movie_id = $1 AND language = $2`, movieID, language)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = recordMovieRevisions(ctx, tx, RevisionTitles, userID, `id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET version = version + 1 WHERE id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m TitleModel) GetAllForMovie(movieID int64) ([]*AlternateTitle, error) {
+
+	query := `
+			SELECT movie_id, language, title
+			FROM movie_titles
+			WHERE movie_id = $1
+			ORDER BY language ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := []*AlternateTitle{}
+
+	for rows.Next() {
+		var title AlternateTitle
+
+		err := rows.Scan(&title.MovieID, &title.Language, &title.Title)
+		if err != nil {
+			return nil, err
+		}
+
+		titles = append(titles, &title)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return titles, nil
+}
+
+// GetBestForMovies returns, for each of the given movies that has one, the
+// title in the most preferred of languages, which should already have been
+// expanded with LanguageFallbacks. Results are keyed by movie ID.
+func (m TitleModel) GetBestForMovies(movieIDs []int64, languages []string) (map[int64]*AlternateTitle, error) {
+
+	titles := make(map[int64]*AlternateTitle, len(movieIDs))
+
+	if len(movieIDs) == 0 || len(languages) == 0 {
+		return titles, nil
+	}
+
+	query := `
+			SELECT DISTINCT ON (movie_id) movie_id, language, title
+			FROM movie_titles
+			WHERE movie_id = ANY($1) AND language = ANY($2)
+			ORDER BY movie_id, array_position($2::text[], language)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs), pq.Array(languages))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var title AlternateTitle
+
+		err := rows.Scan(&title.MovieID, &title.Language, &title.Title)
+		if err != nil {
+			return nil, err
+		}
+
+		titles[title.MovieID] = &title
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return titles, nil
+}
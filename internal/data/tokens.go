@@ -6,22 +6,54 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/Emmanuel-MacAnThony/greenlight/internal/validator"
+	"github.com/lib/pq"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
+	ScopeRefresh        = "refresh"
 )
 
+// ErrRefreshTokenReused is returned when a refresh token that has already
+// been exchanged is presented again. Only one of the legitimate client and
+// whoever copied the token can have the latest one, so the whole family is
+// revoked rather than guessing which.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// IP and UserAgent record the client an authentication token was issued
+	// to, so the user can tell their sessions apart.
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+	// Family groups the access and refresh tokens descended from a single
+	// login. It is empty for tokens of other scopes.
+	Family string `json:"-"`
+}
+
+// Session describes a login without revealing its tokens. Logins that issued
+// a refresh token are identified by the family's current refresh token, and
+// older logins by their authentication token. Current marks the session the
+// listing was requested with.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
 }
 
 type TokenModel struct {
@@ -65,19 +97,167 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
-	query := `
-			INSERT INTO tokens (hash, user_id, expiry, scope)
-			VALUES ($1, $2, $3, $4)`
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// newSessionTokens generates a short-lived authentication token and a
+// longer-lived refresh token for family.
+func newSessionTokens(userID int64, accessTTL, refreshTTL time.Duration, family, ip, userAgent string) (*Token, *Token, error) {
+
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.Family = family
+		token.IP = ip
+		token.UserAgent = userAgent
+	}
+
+	return access, refresh, nil
+}
+
+// NewSession issues the authentication and refresh tokens for a new login,
+// as a new token family, recording the client they were issued to.
+func (m TokenModel) NewSession(userID int64, accessTTL, refreshTTL time.Duration, ip, userAgent string) (*Token, *Token, error) {
+
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	family := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	access, refresh, err := newSessionTokens(userID, accessTTL, refreshTTL, family, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	for _, token := range []*Token{access, refresh} {
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// Rotate exchanges a refresh token for a new authentication token and a new
+// refresh token in the same family. The old refresh token is kept, marked as
+// rotated, so that if it's presented again the family can be revoked and
+// ErrRefreshTokenReused returned. The family's previous authentication tokens
+// are revoked, since the client has been given a replacement.
+func (m TokenModel) Rotate(refreshPlaintext string, accessTTL, refreshTTL time.Duration, ip, userAgent string) (*Token, *Token, error) {
+
+	refreshHash := sha256.Sum256([]byte(refreshPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		userID    int64
+		family    string
+		rotatedAt *time.Time
+	)
+
+	query := `
+			SELECT user_id, family, rotated_at
+			FROM tokens
+			WHERE hash = $1 AND scope = $2 AND expiry > NOW()
+			FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, refreshHash[:], ScopeRefresh).Scan(&userID, &family, &rotatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if rotatedAt != nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, family)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET rotated_at = NOW(), last_used_at = NOW() WHERE hash = $1`, refreshHash[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1 AND scope = $2`, family, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := newSessionTokens(userID, accessTTL, refreshTTL, family, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+func insertToken(ctx context.Context, db execer, token *Token) error {
+	query := `
+			INSERT INTO tokens (hash, user_id, expiry, scope, ip, user_agent, family)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent, token.Family}
+
+	_, err := db.ExecContext(ctx, query, args...)
 	return err
 }
 
+func (m TokenModel) Insert(token *Token) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertToken(ctx, m.DB, token)
+}
+
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
 			DELETE FROM tokens
@@ -89,3 +269,163 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAllScopesForUser removes every token the user holds, whatever its
+// scope, such as when their password is reset.
+func (m TokenModel) DeleteAllScopesForUser(userID int64) error {
+	query := `
+			DELETE FROM tokens
+			WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// sessionTokenScopes are the scopes of the tokens that make up sessions.
+var sessionTokenScopes = []string{ScopeAuthentication, ScopeRefresh}
+
+// Revoke deletes the token with the given plaintext, along with the rest of
+// its family if it belongs to one.
+func (m TokenModel) Revoke(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+			DELETE FROM tokens
+			WHERE hash = $1
+			OR family = (SELECT family FROM tokens WHERE hash = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:])
+	return err
+}
+
+// GetSessionsForUser lists the user's sessions that can still be used, most
+// recently started first. The one currentPlaintext belongs to is marked
+// Current. A session started when its family's oldest token was issued and
+// was last used when any of its tokens was.
+func (m TokenModel) GetSessionsForUser(userID int64, currentPlaintext string) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentPlaintext))
+
+	query := `
+			WITH current AS (
+				SELECT hash, family FROM tokens WHERE hash = $4
+			)
+			SELECT sessions.id,
+				COALESCE((SELECT min(created_at) FROM tokens WHERE family = sessions.family), sessions.created_at) AS started_at,
+				COALESCE((SELECT max(last_used_at) FROM tokens WHERE family = sessions.family), sessions.last_used_at),
+				sessions.expiry, sessions.ip, sessions.user_agent,
+				EXISTS (SELECT 1 FROM current WHERE current.hash = sessions.hash OR current.family = sessions.family)
+			FROM tokens sessions
+			WHERE sessions.user_id = $1 AND sessions.expiry > NOW()
+			AND (
+				(sessions.scope = $2 AND sessions.rotated_at IS NULL)
+				OR (sessions.scope = $3 AND sessions.family IS NULL)
+			)
+			ORDER BY started_at DESC, sessions.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeRefresh, ScopeAuthentication, currentHash[:])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.IP,
+			&session.UserAgent,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession deletes one of the user's sessions by the ID it is listed
+// under, including every token in its family.
+func (m TokenModel) RevokeSession(userID, sessionID int64) error {
+	query := `
+			WITH session AS (
+				SELECT hash, family FROM tokens
+				WHERE id = $1 AND user_id = $2 AND scope = ANY($3)
+			)
+			DELETE FROM tokens
+			WHERE hash IN (SELECT hash FROM session)
+			OR family IN (SELECT family FROM session)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, sessionID, userID, pq.Array(sessionTokenScopes))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions deletes the tokens of all of the user's sessions except
+// the one currentPlaintext belongs to, returning how many sessions there
+// were.
+func (m TokenModel) RevokeOtherSessions(userID int64, currentPlaintext string) (int64, error) {
+	currentHash := sha256.Sum256([]byte(currentPlaintext))
+
+	query := `
+			WITH current AS (
+				SELECT hash, family FROM tokens WHERE hash = $3
+			),
+			revoked AS (
+				DELETE FROM tokens
+				WHERE user_id = $1 AND scope = ANY($2)
+				AND hash NOT IN (SELECT hash FROM current)
+				AND (family IS NULL OR family NOT IN (SELECT family FROM current WHERE family IS NOT NULL))
+				RETURNING family
+			)
+			SELECT count(DISTINCT family) + count(*) FILTER (WHERE family IS NULL)
+			FROM revoked`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var revoked int64
+
+	err := m.DB.QueryRowContext(ctx, query, userID, pq.Array(sessionTokenScopes), currentHash[:]).Scan(&revoked)
+	if err != nil {
+		return 0, err
+	}
+
+	return revoked, nil
+}
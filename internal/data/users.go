@@ -24,7 +24,10 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	// PendingEmail is the address the user has asked to change their email
+	// to, until they confirm it with the token sent there.
+	PendingEmail *string `json:"pending_email,omitempty"`
+	Version      int     `json:"-"`
 }
 
 type UserModel struct {
@@ -117,7 +120,7 @@ func (m UserModel) Insert(user *User) error {
 func (m UserModel) GetByEmail(email string) (*User, error) {
 
 	query := `
-			SELECT id, created_at, name, email, password_hash, activated, version
+			SELECT id, created_at, name, email, password_hash, activated, pending_email, version
 			FROM users
 			WHERE email = $1`
 
@@ -133,6 +136,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.PendingEmail,
 		&user.Version,
 	)
 
@@ -152,8 +156,8 @@ func (m UserModel) UpdateUser(user *User) error {
 
 	query := `
 				UPDATE users
-				SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-				WHERE id = $5 AND version = $6
+				SET name = $1, email = $2, password_hash = $3, activated = $4, pending_email = $5, version = version + 1
+				WHERE id = $6 AND version = $7
 				RETURNING version`
 
 	args := []interface{}{
@@ -162,6 +166,7 @@ func (m UserModel) UpdateUser(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.PendingEmail,
 		user.ID,
 		user.Version,
 	}
@@ -188,14 +193,29 @@ func (m UserModel) UpdateUser(user *User) error {
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
 	query := `
-			SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+			SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.pending_email, users.version
 			FROM users
 			INNER JOIN tokens
 			ON users.id = tokens.user_id
 			WHERE tokens.hash = $1
 			AND tokens.scope = $2
 			AND tokens.expiry > $3`
+
+	// Looking up an authentication token also records that it was used, for
+	// the sessions listing, in the same statement. To save a write on every
+	// request, the time is only updated once a minute.
+	if tokenScope == ScopeAuthentication {
+		query = `
+			WITH used AS (
+				UPDATE tokens
+				SET last_used_at = NOW()
+				WHERE hash = $1 AND scope = $2 AND expiry > $3
+				AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+			)` + query
+	}
+
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 
 	var user User
@@ -210,6 +230,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.PendingEmail,
 		&user.Version,
 	)
 
@@ -0,0 +1,106 @@
+// Package imaging decodes uploaded images and makes thumbnails of them using
+// only the standard library.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+
+	// Register the decoders for the formats uploads may use.
+	_ "image/gif"
+	_ "image/png"
+)
+
+// ContentTypes lists the image types Decode accepts, as sniffed by
+// http.DetectContentType.
+var ContentTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// MaxPixels bounds the size of the images Decode will decode, so that a
+// small, highly compressed upload can't be used to exhaust memory.
+const MaxPixels = 40_000_000
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooLarge          = errors.New("image dimensions too large")
+)
+
+// Decode reads an image, checking its dimensions before decoding the pixels.
+func Decode(b []byte) (image.Image, error) {
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	return img, nil
+}
+
+// Thumbnail scales img down to fit within maxWidth by maxHeight, keeping its
+// aspect ratio. Each output pixel is the average of the source pixels it
+// covers. Images that already fit are copied unscaled.
+func Thumbnail(img image.Image, maxWidth, maxHeight int) image.Image {
+
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	scale := 1.0
+	if s := float64(maxWidth) / float64(srcW); s < scale {
+		scale = s
+	}
+	if s := float64(maxHeight) / float64(srcH); s < scale {
+		scale = s
+	}
+
+	dstW := max(1, int(float64(srcW)*scale))
+	dstH := max(1, int(float64(srcH)*scale))
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		y0 := bounds.Min.Y + y*srcH/dstH
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcH/dstH)
+
+		for x := 0; x < dstW; x++ {
+			x0 := bounds.Min.X + x*srcW/dstW
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcW/dstW)
+
+			var r, g, b, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
+
+// EncodeJPEG writes img as a JPEG. Thumbnails are always JPEGs, whatever the
+// format of the original.
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+}
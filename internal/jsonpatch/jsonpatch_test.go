@@ -0,0 +1,138 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   error
+	}{
+		{"add member", `{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`, nil},
+		{"add null", `{"a":1}`, `[{"op":"add","path":"/b","value":null}]`, `{"a":1,"b":null}`, nil},
+		{"add to array", `{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`, nil},
+		{"append to array", `{"a":[1]}`, `[{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`, nil},
+		{"add without value", `{"a":1}`, `[{"op":"add","path":"/b"}]`, ``, ErrInvalidPatch},
+		{"remove member", `{"a":1,"b":2}`, `[{"op":"remove","path":"/b"}]`, `{"a":1}`, nil},
+		{"remove missing", `{"a":1}`, `[{"op":"remove","path":"/b"}]`, ``, ErrPathNotFound},
+		{"replace member", `{"a":1}`, `[{"op":"replace","path":"/a","value":"x"}]`, `{"a":"x"}`, nil},
+		{"replace with null", `{"a":1}`, `[{"op":"replace","path":"/a","value":null}]`, `{"a":null}`, nil},
+		{"replace missing", `{"a":1}`, `[{"op":"replace","path":"/b","value":1}]`, ``, ErrPathNotFound},
+		{"replace whole document", `{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`, nil},
+		{"test passes", `{"a":1.0}`, `[{"op":"test","path":"/a","value":1}]`, `{"a":1.0}`, nil},
+		{"test null passes", `{"a":null}`, `[{"op":"test","path":"/a","value":null}]`, `{"a":null}`, nil},
+		{"test null fails", `{"a":1}`, `[{"op":"test","path":"/a","value":null}]`, ``, ErrTestFailed},
+		{"test object key order", `{"a":{"x":1,"y":2}}`, `[{"op":"test","path":"/a","value":{"y":2,"x":1}}]`, `{"a":{"x":1,"y":2}}`, nil},
+		{"move member", `{"a":1}`, `[{"op":"move","from":"/a","path":"/b"}]`, `{"b":1}`, nil},
+		{"move into own child", `{"a":{}}`, `[{"op":"move","from":"/a","path":"/a/b"}]`, ``, ErrInvalidPatch},
+		{"copy member", `{"a":[1]}`, `[{"op":"copy","from":"/a","path":"/b"}]`, `{"a":[1],"b":[1]}`, nil},
+		{"escaped pointer", `{"a/b":1,"c~d":2}`, `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/c~0d"}]`, `{}`, nil},
+		{"leading zero index", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/01"}]`, ``, ErrPathNotFound},
+		{"unknown op", `{}`, `[{"op":"frobnicate","path":"/a"}]`, ``, ErrInvalidPatch},
+		{"unknown member", `{}`, `[{"op":"add","path":"/a","value":1,"extra":true}]`, ``, ErrInvalidPatch},
+		{"not an array", `{}`, `{"op":"add","path":"/a","value":1}`, ``, ErrInvalidPatch},
+		{"failure aborts patch", `{"a":1}`, `[{"op":"add","path":"/b","value":2},{"op":"test","path":"/a","value":2}]`, ``, ErrTestFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v; want %v", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"replace member", `{"a":1,"b":2}`, `{"a":3}`, `{"a":3,"b":2}`},
+		{"remove member", `{"a":1,"b":2}`, `{"b":null}`, `{"a":1}`},
+		{"merge nested", `{"a":{"x":1,"y":2}}`, `{"a":{"y":null,"z":3}}`, `{"a":{"x":1,"z":3}}`},
+		{"replace array", `{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{"replace non-object", `{"a":1}`, `[1]`, `[1]`},
+		{"object over scalar", `{"a":1}`, `{"a":{"b":null,"c":1}}`, `{"a":{"c":1}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestOperationUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		js       string
+		hasValue bool
+		value    string
+	}{
+		{"missing value", `{"op":"remove","path":"/a"}`, false, ""},
+		{"null value", `{"op":"add","path":"/a","value":null}`, true, "null"},
+		{"object value", `{"op":"add","path":"/a","value":{"b":1}}`, true, `{"b":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var op Operation
+
+			err := json.Unmarshal([]byte(tt.js), &op)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if (op.Value != nil) != tt.hasValue {
+				t.Fatalf("got value present %t; want %t", op.Value != nil, tt.hasValue)
+			}
+
+			if tt.hasValue && string(*op.Value) != tt.value {
+				t.Errorf("got value %s; want %s", *op.Value, tt.value)
+			}
+		})
+	}
+}
+
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	g, err := decode(got)
+	if err != nil {
+		t.Fatalf("invalid result %s: %v", got, err)
+	}
+
+	w, err := decode([]byte(want))
+	if err != nil {
+		t.Fatalf("invalid expectation %s: %v", want, err)
+	}
+
+	if !equal(g, w) {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores files in a directory on the local filesystem. It also serves
+// them, for mounting under the path of its base URL.
+type Local struct {
+	root    string
+	baseURL string
+	prefix  string
+}
+
+// NewLocal returns a Local storing files under root, creating the directory
+// if need be. URLs are formed by appending keys to baseURL.
+func NewLocal(root, baseURL string) (*Local, error) {
+
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{root: root, baseURL: u.String(), prefix: u.Path}, nil
+}
+
+// path returns the filesystem path for key, refusing keys that would escape
+// the root directory.
+func (l *Local) path(key string) (string, error) {
+
+	if key == "" || path.Clean("/"+key) != "/"+key {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file first and renames it into place, so readers
+// never see a partly written file.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + key
+}
+
+// MountPath returns the path of the base URL, which ServeHTTP expects to be
+// mounted under. A base URL with no path, such as that of a dedicated host,
+// would need the handler mounted at the root, so "" is returned for those.
+func (l *Local) MountPath() string {
+	if !strings.HasPrefix(l.prefix, "/") {
+		return ""
+	}
+	return l.prefix
+}
+
+// ServeHTTP serves stored files by the part of the request path following
+// the path of the base URL. Directory listings are not served.
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, l.prefix), "/")
+
+	name, err := l.path(key)
+	if err != nil || strings.HasSuffix(r.URL.Path, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	info, err := os.Stat(name)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, name)
+}
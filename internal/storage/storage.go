@@ -0,0 +1,32 @@
+// Package storage keeps uploaded files, such as movie images, behind an
+// interface so the backend can be swapped without touching the handlers.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage stores files under slash-separated keys such as
+// "movies/12/poster-1a2b.jpg".
+type Storage interface {
+	// Put stores the contents of r under key, replacing any existing file.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Delete removes the file stored under key. Deleting a key that doesn't
+	// exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the address clients can fetch the file from.
+	URL(key string) string
+}
+
+// Server is implemented by backends that serve their own files, like Local.
+// MountPath returns the request path the handler expects to be mounted under,
+// or "" if it can't be mounted.
+type Server interface {
+	http.Handler
+	MountPath() string
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+id bigserial PRIMARY KEY,
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+version integer NOT NULL,
+title text NOT NULL,
+year integer NOT NULL,
+runtime integer NOT NULL,
+genres text[] NOT NULL,
+changed_by bigint REFERENCES users ON DELETE SET NULL,
+changed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+UNIQUE (movie_id, version)
+);
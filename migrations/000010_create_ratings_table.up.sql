@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS ratings (
+id bigserial PRIMARY KEY,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+score integer NOT NULL,
+review text NOT NULL DEFAULT '',
+version integer NOT NULL DEFAULT 1,
+UNIQUE (movie_id, user_id)
+);
+ALTER TABLE ratings ADD CONSTRAINT ratings_score_check CHECK (score BETWEEN 1 AND 10);
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS average_rating numeric(4, 2) NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS movies_average_rating_idx ON movies (average_rating, id);
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS lists (
+id bigserial PRIMARY KEY,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+name text NOT NULL,
+description text NOT NULL DEFAULT '',
+visibility text NOT NULL DEFAULT 'private',
+is_default bool NOT NULL DEFAULT false,
+version integer NOT NULL DEFAULT 1,
+UNIQUE (user_id, name)
+);
+ALTER TABLE lists ADD CONSTRAINT lists_visibility_check CHECK (visibility IN ('private', 'unlisted', 'public'));
+CREATE UNIQUE INDEX IF NOT EXISTS lists_user_id_default_idx ON lists (user_id) WHERE is_default;
+CREATE INDEX IF NOT EXISTS lists_public_idx ON lists (user_id) WHERE visibility = 'public';
+CREATE TABLE IF NOT EXISTS list_entries (
+list_id bigint NOT NULL REFERENCES lists ON DELETE CASCADE,
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+position integer NOT NULL,
+note text NOT NULL DEFAULT '',
+added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (list_id, movie_id)
+);
+CREATE INDEX IF NOT EXISTS list_entries_position_idx ON list_entries (list_id, position);
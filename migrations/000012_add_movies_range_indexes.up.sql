@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS movies_year_idx ON movies (year, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS movies_runtime_idx ON movies (runtime, id) WHERE deleted_at IS NULL;
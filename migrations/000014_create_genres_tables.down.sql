@@ -0,0 +1,9 @@
+-- Put back the free-text genres of movies still carrying the slugs this
+-- migration gave them. Movies edited since keep their current genres.
+UPDATE movies
+SET genres = movie_genres_backup.original, version = movies.version + 1
+FROM movie_genres_backup
+WHERE movies.id = movie_genres_backup.movie_id AND movies.genres = movie_genres_backup.normalized;
+DROP TABLE IF EXISTS movie_genres_backup;
+DROP TABLE IF EXISTS genre_aliases;
+DROP TABLE IF EXISTS genres;
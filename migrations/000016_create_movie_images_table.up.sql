@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS movie_images (
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+kind text NOT NULL,
+key text NOT NULL,
+thumbnail_key text NOT NULL,
+content_type text NOT NULL,
+width integer NOT NULL,
+height integer NOT NULL,
+size bigint NOT NULL,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (movie_id, kind)
+);
+ALTER TABLE movie_images ADD CONSTRAINT movie_images_kind_check CHECK (kind IN ('poster', 'backdrop'));
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS ratings_user_id_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS ratings_user_id_idx ON ratings (user_id, movie_id) INCLUDE (score);
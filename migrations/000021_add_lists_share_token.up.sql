@@ -0,0 +1,3 @@
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+ALTER TABLE lists ADD COLUMN IF NOT EXISTS share_token text NOT NULL DEFAULT encode(gen_random_bytes(16), 'hex');
+ALTER TABLE lists ADD CONSTRAINT lists_share_token_key UNIQUE (share_token);
@@ -0,0 +1 @@
+-- Lists promoted to defaults can't be told apart from ones created as such.
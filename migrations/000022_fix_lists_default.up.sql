@@ -0,0 +1,4 @@
+UPDATE lists SET is_default = true
+WHERE name = 'watchlist' AND NOT EXISTS (
+    SELECT 1 FROM lists defaults WHERE defaults.user_id = lists.user_id AND defaults.is_default
+);
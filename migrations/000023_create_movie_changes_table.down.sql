@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS ratings_changes_trigger ON ratings;
+DROP TRIGGER IF EXISTS movies_changes_trigger ON movies;
+DROP FUNCTION IF EXISTS bump_movie_changes();
+DROP TABLE IF EXISTS movie_changes;
@@ -0,0 +1,23 @@
+-- A single counter bumped by every statement that writes to movies or
+-- ratings, so that anything derived from them can tell whether it is still
+-- current without scanning either table.
+CREATE TABLE IF NOT EXISTS movie_changes (
+id boolean PRIMARY KEY DEFAULT true CHECK (id),
+version bigint NOT NULL DEFAULT 1
+);
+INSERT INTO movie_changes DEFAULT VALUES ON CONFLICT DO NOTHING;
+
+CREATE OR REPLACE FUNCTION bump_movie_changes() RETURNS trigger AS $$
+BEGIN
+	UPDATE movie_changes SET version = version + 1;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_changes_trigger
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON movies
+FOR EACH STATEMENT EXECUTE FUNCTION bump_movie_changes();
+
+CREATE TRIGGER ratings_changes_trigger
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON ratings
+FOR EACH STATEMENT EXECUTE FUNCTION bump_movie_changes();
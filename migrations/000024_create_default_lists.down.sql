@@ -0,0 +1 @@
+-- Watchlists created here can't be told apart from ones created at registration.
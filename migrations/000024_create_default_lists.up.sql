@@ -0,0 +1,7 @@
+-- Watchlists used to be created the first time a user's lists were looked
+-- at. They are now created at registration, so create any still missing.
+INSERT INTO lists (user_id, name, visibility, is_default)
+SELECT users.id, 'watchlist', 'private', true
+FROM users
+WHERE NOT EXISTS (SELECT 1 FROM lists WHERE lists.user_id = users.id AND lists.is_default)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1 @@
+ALTER TABLE movie_revisions DROP COLUMN IF EXISTS reason;
@@ -0,0 +1 @@
+ALTER TABLE movie_revisions ADD COLUMN IF NOT EXISTS reason text NOT NULL DEFAULT 'details';